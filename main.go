@@ -2,24 +2,39 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"math/big"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"go.sia.tech/siad/build"
 	"go.sia.tech/siad/crypto"
 	"go.sia.tech/siad/types"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
 	"golang.org/x/crypto/ssh/terminal"
 	"lukechampine.com/flagg"
 	"lukechampine.com/sialedger"
@@ -27,39 +42,130 @@ import (
 	"lukechampine.com/walrus"
 )
 
+const version = "v0.1.0"
+
 var (
 	// to be supplied at build time
 	githash   = "?"
 	builddate = "?"
 )
 
+// verbose causes currency values to be displayed in hastings as well as SC.
+var verbose bool
+
+// jsonIndent is the indentation used when writing JSON. If it is empty, JSON
+// is written in compact form.
+var jsonIndent = "  "
+
+func encodeJSON(v interface{}) []byte {
+	if jsonIndent == "" {
+		js, _ := json.Marshal(v)
+		return js
+	}
+	js, _ := json.MarshalIndent(v, "", jsonIndent)
+	return js
+}
+
+// quiet suppresses transaction summaries and redirects informational output
+// to stderr, so that stdout contains only essential results.
+var quiet bool
+
+// yes skips interactive confirmations, e.g. for duplicate recipients or a
+// transaction without a miner fee.
+var yes bool
+
+// failOnReuse causes operations that would reuse an address to abort rather
+// than merely warn.
+var failOnReuse bool
+
+// warnReuse prints msg as a warning, or exits with msg as an error if
+// failOnReuse is set.
+func warnReuse(msg string) {
+	if failOnReuse {
+		fatalf("%v\nAborting due to -fail-on-reuse.", msg)
+	}
+	fmt.Fprintln(infoOut(), msg)
+}
+
+// infoOut returns the writer that informational output should be written to.
+func infoOut() io.Writer {
+	if quiet {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
 var (
 	rootUsage = `Usage:
     walrus-cli [flags] [action]
 
 Actions:
-    seed            generate a seed
-    balance         view current balance
-    consensus       view blockchain information
-    addresses       list addresses
-    addr            generate an address
-    txn             create a transaction
-    split           create an output-splitting transaction
-    defrag          create an output-merging transaction
-    sign            sign a transaction
-    broadcast       broadcast a transaction
-    transactions    list transactions
+    seed             generate a seed
+    balance          view current balance
+    overview         view a summary of the wallet
+    consensus        view blockchain information
+    addresses        list addresses
+    addr             generate an address
+    utxos            list unspent outputs
+    compare-keys     compare seed and Ledger addresses
+    watch-batch      add addresses from a list of public keys
+    ledger-info      display the Ledger Sia app version
+    fee-market       display the current transaction fee
+    cancel           attempt to cancel an unconfirmed transaction
+    prune-addresses  stop tracking empty, inactive addresses
+    export-addresses derive and export a range of addresses
+    batch            run a sequence of commands from a file
+    txn              create a transaction
+    split            create an output-splitting transaction
+    defrag           create an output-merging transaction
+    sign             sign a transaction
+    broadcast        broadcast a transaction
+    decode           display the contents of a transaction
+    transactions     list transactions
+    mempool          list unconfirmed transactions
+    label            annotate transactions
+    alias            name addresses
 `
 	versionUsage = rootUsage
 	balanceUsage = `Usage:
     walrus-cli balance
 
 Reports the current balance.
+
+If -watch is provided, the balance is polled at the specified interval (e.g.
+30s) and a line is printed whenever it changes, until interrupted with Ctrl-C.
+`
+	overviewUsage = `Usage:
+    walrus-cli overview
+
+Reports a summary of the wallet: its confirmed balance, its balance once any
+pending (broadcast but unconfirmed) transactions are confirmed, the number of
+unspent outputs and tracked addresses, and the current block height.
+
+The Siafund balance is not included, because the walrus server does not report
+Siafund outputs.
 `
 	seedUsage = `Usage:
     walrus-cli seed
 
-Generates a random seed.
+    walrus-cli seed -encrypt [file]
+
+    walrus-cli seed -convert [phrase|entropy]
+
+Generates a random seed. If -preview is provided, the first n addresses
+derived from the seed are also displayed, which can be used to confirm that the
+seed was recorded correctly.
+
+If -encrypt is provided, no seed is generated; instead, an existing seed is
+read (as with any other command) and written to file, encrypted with a key
+derived from a passphrase. The file may then be passed to the -seed-file flag,
+which prompts for the passphrase whenever the seed is needed. The passphrase is
+never stored.
+
+If -convert is provided, no seed is generated; instead, the provided seed,
+given either as a phrase or as 16 bytes of hex-encoded entropy, is converted to
+the canonical walrus seed phrase. The first address derived from the seed is
+also displayed so that the conversion can be confirmed.
 `
 	consensusUsage = `Usage:
     walrus-cli consensus
@@ -70,6 +176,10 @@ Reports the current block height and change ID.
     walrus-cli addresses
 
 Lists addresses tracked by the wallet.
+
+By default, addresses are listed in the order reported by the server. The -sort
+flag can be used to sort them by key index ('index') or lexicographically
+('addr'). Sorting by key index also displays the index of each address.
 `
 	addrUsage = `Usage:
     walrus-cli addr
@@ -77,6 +187,117 @@ Lists addresses tracked by the wallet.
 
 Generates an address. If no key index is provided, the lowest unused key index
 is used. The address is added to the wallet's set of tracked addresses.
+
+If the specified key index is below the lowest unused index, it has likely been
+used before, so confirmation is required unless -yes is provided.
+
+If -no-register is provided, the address is derived and displayed without
+contacting the server, and a key index must be specified.
+
+If -json is provided, the address, key index, public key, and unlock conditions
+are printed to stdout as JSON, and all other output is written to stderr.
+
+If -gap-scan is provided, addresses are derived from the seed in order and
+compared against the wallet's transaction history and unspent outputs until
+-gap-limit consecutive unused addresses are found; the first of these is
+reported as the next unused key index. This is useful after restoring a seed
+on a new server, where the server's notion of the next index may be wrong.
+
+If -balance is provided, the balance and number of unspent outputs (including
+unconfirmed outputs) of the address at the specified key index are reported. An
+address may be given instead of a key index. No address is generated.
+
+If -verify is provided, the address at the specified key index is re-derived
+(on the device, if -ledger is set) and compared to the address that the server
+reports for that index. A mismatch indicates that the server or host may be
+compromised.
+`
+	utxosUsage = `Usage:
+    walrus-cli utxos
+
+Lists the wallet's unspent outputs (including those created by unconfirmed
+transactions), along with the address and key index of each, followed by
+their total value. If -sort is provided, outputs are sorted by value ('asc' or
+'desc').
+`
+	compareKeysUsage = `Usage:
+    walrus-cli compare-keys [start] [end]
+
+Derives the addresses at key indices start through end (inclusive) from both a
+seed and a Ledger Nano S, and reports whether they match. A mismatch means that
+the seed and the device do not correspond. You will be prompted to approve each
+address on the device.
+`
+	watchBatchUsage = `Usage:
+    walrus-cli watch-batch [file]
+
+Adds the addresses corresponding to a list of public keys to the wallet. Each
+line of the file specifies a key index and the public key at that index,
+separated by a comma (e.g. 3,ed25519:abcd...). This allows a watch-only wallet
+to be set up from public keys exported from a hardware wallet, without access
+to the seed. Addresses that the server is already tracking are skipped.
+`
+	ledgerInfoUsage = `Usage:
+    walrus-cli ledger-info
+
+Displays the version of the Sia app running on the connected Ledger Nano S, and
+warns if it is older than the oldest version known to work with walrus-cli.
+`
+	feeMarketUsage = `Usage:
+    walrus-cli fee-market
+
+Displays the fee rate recommended by the server, along with the resulting fee
+for transactions of a few typical sizes. The walrus API does not currently
+report fees paid in recent blocks, so only the recommended rate is available.
+`
+	cancelUsage = `Usage:
+    walrus-cli cancel [txn]
+
+Attempts to cancel the provided unconfirmed transaction by creating, signing,
+and broadcasting a conflicting transaction that spends the same inputs back to
+a new wallet address with a higher miner fee. Sia has no replace-by-fee
+mechanism, so the cancellation only succeeds if miners include the new
+transaction instead of the original; if the original is confirmed first, the
+new transaction becomes invalid and the original payment stands.
+`
+	pruneAddressesUsage = `Usage:
+    walrus-cli prune-addresses
+
+Removes addresses from the wallet's set of tracked addresses if they hold no
+outputs (including unconfirmed outputs) and have not appeared in a transaction
+within the last -inactive blocks. Addresses that have never been used are kept,
+since they may have been given out to receive payments.
+
+Once an address is removed, the server no longer watches it, so any coins sent
+to it later will not appear in the wallet until it is added again with the
+addr command. Use -dry-run to list the addresses that would be removed.
+`
+	exportAddressesUsage = `Usage:
+    walrus-cli export-addresses [start] [end] [file]
+
+Derives the addresses at key indices start through end (inclusive), adds them
+to the wallet, and writes them, along with their key indices, to file. This is
+useful for provisioning a service with a block of receive addresses. When using
+a Ledger, you will be prompted to approve each address on the device.
+
+The -format flag controls the output format, and may be either 'json' or 'csv'.
+`
+	batchUsage = `Usage:
+    walrus-cli batch [file]
+
+Runs each line of the file as a walrus-cli command, in order, e.g.:
+
+    addr 5
+    txn -change-strategy reuse-smallest addr:10 payment.json
+
+Each command uses the root flags given to the batch command (e.g. -a, -ledger,
+and -seed-file), which may not be overridden within the file. The seed (or
+Ledger connection) is shared by all commands, so you are only prompted for it
+once. Arguments are separated by whitespace; quoting is not supported. Blank
+lines and lines beginning with '#' are ignored. Execution stops at the first
+command that fails, unless -continue-on-error is provided.
+
+Batch files cannot be run with -offline.
 `
 	txnUsage = `Usage:
 walrus-cli txn [outputs] [file]
@@ -84,42 +305,408 @@ walrus-cli txn [outputs] [file]
 Creates a transaction with the provided set of outputs, which are specified as a
 comma-separated list of address:value pairs, where value is specified in SC. The
 inputs are selected automatically, and a change address is generated if needed.
+
+A value of '-' is read from stdin, e.g. to pipe in an amount computed by a
+script. In that case, the seed (if needed) must be supplied via WALRUS_SEED or
+-seed-file.
+
+An output may be given a label, such as an invoice number, by specifying it as
+address:value:label. Labels are stored in the transaction's arbitrary data, so
+they are visible to the recipient (and everyone else), and are displayed by the
+decode command.
+
+The -change-strategy flag controls where change is sent: to a newly-generated
+address ('new', the default), to the address given by -change ('specified'), or
+to the address of the least valuable input being spent ('reuse-smallest'). The
+latter reduces the number of addresses in the wallet at the cost of privacy.
+
+The -change-reuse-window flag offers a middle ground for the 'new' strategy: if
+a change address was generated within the specified duration (e.g. 1h), it is
+reused rather than generating another. The most recent change address is
+recorded in the walrus-cli config directory.
+
+The -equal-split flag adds one output per address, each worth the same value,
+specified as value:addr1,addr2,... If -equal-split is provided, the outputs
+argument may be omitted.
+
+The -timelock flag adds an output that cannot be spent until the specified
+block height, specified as height:pubkey:value. Since Sia enforces timelocks via
+unlock conditions, the recipient must be identified by their public key (e.g.
+ed25519:abcd...) rather than an address. As with -equal-split, the outputs
+argument may be omitted.
+
+The -locktime flag prevents the transaction itself from being confirmed before
+the specified block height. Sia transactions have no locktime field, and the
+unlock conditions of the inputs are fixed by the addresses being spent, so this
+is done by timelocking each signature instead: a signature with a timelock is
+invalid until that height. The flag therefore only takes effect when signing;
+if the transaction is signed later, pass -locktime to the sign command instead.
+
+If -edit is provided, the outputs (if any) are opened in $EDITOR, one
+address:value pair per line, and the transaction is created from the saved
+result. As with -equal-split, the outputs argument may be omitted.
+
+To catch typos in output values, outputs worth less than -min-output (0.01 SC
+by default) are rejected unless -force is provided. Similarly, if an address is
+listed more than once, confirmation is required unless -yes is provided.
+
+The -select flag controls which inputs are spent: the largest inputs
+('largest-first', the default, which minimizes the fee), the smallest inputs
+('smallest-first', which consolidates small outputs), or the set of inputs that
+results in the least change ('branch-and-bound').
+
+If -expect-change is provided, the transaction is not created unless the
+final change is within 0.001 SC of the specified value. The final change
+reflects any fee adjustments, and is zero if the change is added to the miner
+fee. This guards scripts against unexpected coin selection results.
+
+The -combine-with flag specifies the address of a second walrus server whose
+outputs may also be used to fund the transaction, e.g. to spend from a hot and a
+cold wallet at once. Such a transaction must be signed by each wallet in turn:
+
+    walrus-cli sign txn.json
+    walrus-cli -a [other server] -ledger sign txn-signed.json
+
+If -receipt is provided, a JSON file is written that maps each output address
+(including change) to the index, ID, and value of the outputs it receives. An
+address that receives several outputs maps to several entries.
+
+If -confirm-total is provided, the exact total amount sent to recipients must
+be retyped before the transaction is signed and broadcast, unless -yes is
+provided. The summary shows the total without rounding.
+
+If -qr-bundle is provided, the transaction is also written to the specified
+file as a sequence of QR frames, one per line, for transfer to an air-gapped
+signer. walrus-cli does not render the codes itself: each line is the text of
+one QR code, and should be rendered with an external tool (e.g. qrencode) and
+displayed in turn. The frames may be scanned in any order. The signer can then
+reassemble them with 'sign -qr-in'.
+
+If -split-fee is provided, the summary also shows each recipient's share of the
+miner fee, in proportion to the value it receives. This is for accounting only
+and does not change the transaction.
+
+If -no-change is provided, any leftover value is added to the miner fee rather
+than sent to a change output. This avoids creating tiny change outputs that cost
+more to spend than they are worth. If the leftover value exceeds 1 SC,
+confirmation is required unless -yes is provided.
+
+The -sort-outputs flag orders the outputs by value ('asc' or 'desc') rather
+than in the order given, so that the position of the change output does not
+reveal which output is change. It may not be combined with -randomize-outputs.
+
+If -append is provided and file already contains a transaction or transaction
+set, the new transaction is appended to it, forming a JSON array that can be
+passed to the sign and broadcast commands.
 `
 	splitUsage = `Usage:
 walrus-cli split [n] [value] [file]
+walrus-cli split -estimate [value]
 
 Creates a transaction that splits the wallet's existing inputs into n outputs,
 each with the specified value. The inputs are selected automatically, and a
 change address is generated if needed.
+
+If -estimate is provided, no transaction is created; instead, the maximum
+number of outputs of the specified value that the wallet can fund is reported.
+
+If -append is provided, the transaction is appended to the transaction set in
+file rather than overwriting it.
 `
 	defragUsage = `Usage:
 walrus-cli defrag [value] [file]
 
 Creates a transaction that merges inputs worth less than value into one output.
-To avoid exceeding the maximum transaction size, at most 100 inputs will be
-selected, so it may be necessary to run this command multiple times.
+To avoid exceeding the maximum transaction size, at most 100 inputs (or the
+value of -max-inputs, if provided) will be selected, so it may be necessary to
+run this command multiple times.
+
+If -dust is provided, the selected outputs are swept to the specified address
+(e.g. a recipient or another wallet) instead of being merged into a wallet
+address. Outputs worth value or more are left intact, so this cleans up small
+outputs without moving the rest of the balance. The number of outputs swept
+and their total are displayed. (There is no separate sweep command; defrag
+with -dust performs a dust sweep.)
+
+If -estimate-only is provided, the total value of the selected outputs, the
+miner fee, and the net amount that will be received are displayed, and no
+transaction is created. In this case the file argument may be omitted. No
+change address is generated, so the estimate does not modify the wallet.
+Combined with -dust, this previews a dust sweep.
 `
 	signUsage = `Usage:
     walrus-cli sign [txn]
 
-Signs all wallet-controlled inputs of the provided transaction.
+Signs all wallet-controlled inputs of the provided transaction. The file may
+also contain a JSON array of transactions, in which case each transaction in
+the set is signed.
+
+If -broadcast is provided, a summary of each transaction is displayed before it
+is signed, since it will be broadcast without further review.
+
+Inputs with non-standard unlock conditions (e.g. multisig) are also signed if
+any of their public keys belong to the wallet; only the matching key slots are
+signed, so other signers may need to add their signatures as well.
+
+The -only-inputs flag restricts signing to the specified inputs, given as a
+comma-separated list of input indices (e.g. 0,2). Each must be controlled by the
+wallet. The remaining inputs are left unsigned, e.g. for co-signers.
+
+The -key-indices flag may be used to specify which inputs to sign and the key
+index of each, as a comma-separated list of input:key pairs (e.g. 0:5,1:7).
+This avoids querying the server for key indices, so it is required when signing
+with -offline.
+
+The -locktime flag timelocks each signature to the specified block height, so
+that the transaction cannot be confirmed before then.
+
+If -parent-outputs is provided, the values of the outputs being spent are read
+from the specified file (as produced by 'utxos -json' on a machine that can
+reach the server), so that the input values and fee can be verified when
+signing offline.
+
+If -qr-in is provided, the file is read as a list of scanned QR frames, one per
+line, as produced by 'txn -qr-bundle'. The frames may be in any order. The
+signed transaction is written as JSON.
 `
 	broadcastUsage = `Usage:
     walrus-cli broadcast [txn]
 
-Broadcasts the provided transaction.
+Broadcasts the provided transaction. The file may also contain a JSON array of
+dependent transactions, which are broadcast together in the order given.
+
+A transaction set that pays no miner fee is unlikely to ever be confirmed, so
+broadcasting one requires confirmation unless -yes is provided.
+
+If -chunk is provided, a large transaction set is submitted in batches of at
+most that many transactions, in the order given. Parents must therefore appear
+before the transactions that spend their outputs. If a batch is rejected, the
+earlier batches have already been broadcast and are not retried.
+`
+	decodeUsage = `Usage:
+    walrus-cli decode [txn]
+
+Displays the contents of the provided transaction (or transaction set). If the
+walrus server is reachable, the value of each input spending a wallet output is
+resolved, and the miner fee is verified against the difference between the
+input and output values. Each such input is also annotated with the number of
+confirmations of the output it spends, which indicates the risk of that output
+being double-spent or reorganized away.
+
+If -resolve is provided, addresses with an alias (see 'walrus-cli alias') are
+displayed by name. With -v, the raw address follows the name in parentheses.
 `
 	transactionsUsage = `Usage:
 walrus-cli transactions
 
 Lists transactions relevant to the wallet.
+
+If -time is provided, an estimate of when each transaction was confirmed is
+also displayed. The estimate is derived from the current block height and the
+target block time, so it may be off by several hours for older transactions.
+
+If -since is provided, only transactions confirmed after the specified block
+height (or after the block containing the specified transaction) are displayed,
+along with any unconfirmed transactions. A marker for the next incremental
+fetch is printed to stderr afterwards.
+
+Internal transactions, whose inflow equals their outflow, do not change the
+balance and are omitted unless -all is provided. (They are always included in
+-json output.)
+
+If -show-inputs is provided, the wallet outputs spent by each transaction are
+listed beneath it, along with their values.
+
+If -export is provided, the full details of each transaction (including
+internal ones) are written to the specified file as a JSON array, in the same
+form as -json, for archival or analysis.
+
+If -summary is provided, the total inflow, outflow, and net change of the
+displayed transactions are printed afterwards.
+
+If -id is provided, the full details of the specified transaction are
+displayed instead.
+
+If -resolve is provided, the aliases (see 'walrus-cli alias') of any addresses
+involved in each transaction are listed after it, and addresses with an alias
+are displayed by name in the output of -id. With -v, the raw address follows
+the name in parentheses.
+
+The -format flag customizes how each transaction is displayed. It accepts
+either a preset ('default' or 'compact') or a Go template, which may reference
+the fields {{.TxID}}, {{.Height}}, {{.Inflow}}, {{.Outflow}}, {{.Delta}},
+{{.Label}}, and {{.Age}} (only available with -time). For example:
+
+    walrus-cli transactions -format '{{.TxID}} {{.Height}} {{.Inflow}} {{.Outflow}}'
+`
+	mempoolUsage = `Usage:
+    walrus-cli mempool
+
+Lists transactions relevant to the wallet that have not yet been confirmed.
+`
+	labelUsage = `Usage:
+    walrus-cli label [action]
+
+Actions:
+    set             set the label of a transaction
+    list            list labeled transactions
+
+Labels are stored locally and are never shared with the server or the network.
+`
+	labelSetUsage = `Usage:
+    walrus-cli label set [txid] [label]
+
+Sets the label of the specified transaction, replacing any existing label.
+`
+	labelListUsage = `Usage:
+    walrus-cli label list
+
+Lists all labeled transactions.
+`
+	aliasUsage = `Usage:
+    walrus-cli alias [action]
+
+Actions:
+    set             name an address
+    list            list named addresses
+    import          import named addresses from a CSV file
+    export          export named addresses to a CSV file
+
+Aliases are stored locally and are never shared with the server or the network.
+`
+	aliasSetUsage = `Usage:
+    walrus-cli alias set [name] [address]
+
+Names the specified address, replacing any existing address with that name.
+`
+	aliasListUsage = `Usage:
+    walrus-cli alias list
+
+Lists all named addresses.
+`
+	aliasImportUsage = `Usage:
+    walrus-cli alias import [file.csv]
+
+Imports aliases from a CSV file of name,address rows; a name,address header row
+is optional. Every row is validated before any aliases are saved. If a name is
+already assigned to a different address, nothing is imported unless -overwrite
+is provided.
+`
+	aliasExportUsage = `Usage:
+    walrus-cli alias export [file.csv]
+
+Writes all aliases to a CSV file of name,address rows, sorted by name.
 `
 )
 
+// inBatch is set while the commands of a batch file are executing. It causes
+// fatal errors to abort only the current command.
+var inBatch bool
+
+// A batchError is raised (via panic) by fatalf when inBatch is set.
+type batchError struct {
+	msg string
+}
+
+// jsonErrors causes fatal errors to be written to stderr as JSON objects.
+var jsonErrors bool
+
+// Exit codes for the main classes of fatal errors.
+const (
+	exitFailure = 1 // any other error
+	exitUsage   = 2 // invalid flags or arguments (as with the flag package)
+	exitNetwork = 3 // the server could not be reached
+	exitFunds   = 4 // the wallet cannot fund a transaction
+	exitSigning = 5 // a transaction could not be signed
+)
+
+// A fundsError indicates that the wallet cannot fund a transaction.
+type fundsError struct {
+	msg string
+}
+
+func (e fundsError) Error() string { return e.msg }
+
+// A usageError indicates that the command was given invalid flags or
+// arguments.
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+// A signingError indicates that a transaction could not be signed.
+type signingError struct {
+	err error
+}
+
+func (e signingError) Error() string { return e.err.Error() }
+func (e signingError) Unwrap() error { return e.err }
+
+// exitCode returns the exit code for err.
+func exitCode(err error) int {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr), errors.Is(err, errOffline):
+		return exitNetwork
+	case errors.As(err, new(fundsError)):
+		return exitFunds
+	case errors.As(err, new(signingError)):
+		return exitSigning
+	case errors.As(err, new(usageError)):
+		return exitUsage
+	}
+	return exitFailure
+}
+
+// fatalf prints an error message and exits, or, if inBatch is set, aborts the
+// current batch command.
+func fatalf(format string, args ...interface{}) {
+	fatal("", fmt.Sprintf(format, args...), exitFailure)
+}
+
 func check(err error, ctx string) {
 	if err != nil {
-		log.Fatalf("%v: %v", ctx, err)
+		fatal(ctx, err.Error(), exitCode(err))
+	}
+}
+
+// checkUsage is like check, but reports err as a usage error.
+func checkUsage(err error, ctx string) {
+	if err != nil {
+		check(usageError{err}, ctx)
+	}
+}
+
+// checkSigning is like check, but reports err as a signing error.
+func checkSigning(err error, ctx string) {
+	if err != nil {
+		check(signingError{err}, ctx)
+	}
+}
+
+// fatal reports msg, along with the context in which it occurred (if any), and
+// exits with the specified code. If jsonErrors is set, the error is written as
+// {"error":msg,"context":ctx,"code":code}.
+func fatal(ctx, msg string, code int) {
+	text := msg
+	if ctx != "" {
+		text = ctx + ": " + msg
 	}
+	if inBatch {
+		panic(batchError{text})
+	} else if jsonErrors {
+		js, _ := json.Marshal(struct {
+			Error   string `json:"error"`
+			Context string `json:"context,omitempty"`
+			Code    int    `json:"code"`
+		}{msg, ctx, code})
+		fmt.Fprintln(os.Stderr, string(js))
+	} else {
+		log.Print(text)
+	}
+	os.Exit(code)
 }
 
 func plural(n int) string {
@@ -129,730 +716,4908 @@ func plural(n int) string {
 	return "s"
 }
 
+// pluralES is like plural, for words such as "alias" that take -es.
+func pluralES(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "es"
+}
+
+// displayPrecision is the number of decimal places that SC values are rounded
+// to when displayed.
+var displayPrecision = 30
+
+// groupThousands inserts a comma between each group of three digits in the
+// integer part of the decimal string s.
+func groupThousands(s string) string {
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i:]
+	}
+	var sb strings.Builder
+	for i, d := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(d)
+	}
+	return sb.String() + frac
+}
+
 func currencyUnits(c types.Currency) string {
 	r := new(big.Rat).SetFrac(c.Big(), types.SiacoinPrecision.Big())
-	sc := strings.TrimRight(r.FloatString(30), "0")
-	return strings.TrimSuffix(sc, ".") + " SC"
+	sc := r.FloatString(displayPrecision)
+	if strings.Contains(sc, ".") {
+		sc = strings.TrimSuffix(strings.TrimRight(sc, "0"), ".")
+	}
+	if state.prettyPrint {
+		sc = groupThousands(sc)
+	}
+	sc += " SC"
+	if verbose {
+		sc += fmt.Sprintf(" (%v H)", c.Big())
+	}
+	return sc
+}
+
+// exactUnits formats c in SC without rounding or thousands separators.
+func exactUnits(c types.Currency) string {
+	r := new(big.Rat).SetFrac(c.Big(), types.SiacoinPrecision.Big())
+	sc := r.FloatString(24) // SiacoinPrecision is 10^24
+	return strings.TrimSuffix(strings.TrimRight(sc, "0"), ".")
 }
 
 func parseCurrency(s string) types.Currency {
 	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
 	if !ok {
 		_, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
-		check(err, "Invalid currency value")
+		checkUsage(err, "Invalid currency value")
 	}
 	return types.SiacoinPrecision.MulRat(r)
 }
 
-func readTxn(filename string) types.Transaction {
-	js, err := ioutil.ReadFile(filename)
-	check(err, "Could not read transaction file")
-	var txn types.Transaction
-	err = json.Unmarshal(js, &txn)
-	check(err, "Could not parse transaction file")
-	return txn
+// confirmRecipientTotal asks the user to retype total, the exact sum sent to
+// recipients, and aborts if the retyped amount differs. Thousands separators
+// and an "SC" suffix are ignored.
+func confirmRecipientTotal(total types.Currency) {
+	fmt.Fprint(infoOut(), "To confirm, please retype the total amount sent to recipients (in SC): ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	line = strings.TrimSuffix(strings.TrimSpace(line), "SC")
+	line = strings.Replace(line, ",", "", -1)
+	if strings.TrimSpace(line) == "" || parseCurrency(line).Cmp(total) != 0 {
+		fatalf("Amount does not match the recipient total of exactly %v SC; aborting.", exactUnits(total))
+	}
 }
 
-func writeTxn(filename string, txn types.Transaction) {
-	js, _ := json.MarshalIndent(txn, "", "  ")
-	js = append(js, '\n')
-	err := ioutil.WriteFile(filename, js, 0666)
-	check(err, "Could not write transaction to disk")
+// parseAmount parses s as an SC value. If s is "-", the value is read from
+// stdin instead.
+func parseAmount(s string) types.Currency {
+	if strings.TrimSpace(s) != "-" {
+		return parseCurrency(s)
+	} else if state.stdinUsed {
+		check(errors.New("only one value may be read from stdin"), "Could not read value")
+	}
+	state.stdinUsed = true
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	check(err, "Could not read value from stdin")
+	return parseCurrency(line)
 }
 
-func getDonationAddr(narwalAddr string) (types.UnlockHash, bool) {
-	u, err := url.Parse(narwalAddr)
-	if err != nil {
-		return types.UnlockHash{}, false
+// parseAddress parses s as an address. The length and character set of s are
+// checked before the checksum, so that common mistakes produce precise errors.
+func parseAddress(s string) (types.UnlockHash, error) {
+	const addrLen = 76 // hex-encoded 32-byte hash and 6-byte checksum
+	var addr types.UnlockHash
+	if len(s) != addrLen {
+		return addr, fmt.Errorf("address is %v chars, expected %v", len(s), addrLen)
 	}
-	path := strings.Split(u.Path, "/")
-	if len(path) < 2 || path[len(path)-2] != "wallet" {
-		return types.UnlockHash{}, false
+	for i, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return addr, fmt.Errorf("address contains invalid character %q at position %v (addresses are hexadecimal)", c, i+1)
+		}
 	}
-	path = append(path[:len(path)-2], "donations")
-	u.Path = strings.Join(path, "/")
-	resp, err := http.Get(u.String())
-	if err != nil {
-		return types.UnlockHash{}, false
+	if err := addr.LoadString(s); err != nil {
+		return addr, errors.New("address checksum is invalid (check for typos)")
 	}
-	defer resp.Body.Close()
-	defer ioutil.ReadAll(resp.Body)
-	var addr types.UnlockHash
-	err = json.NewDecoder(resp.Body).Decode(&addr)
-	return addr, err == nil
+	return addr, nil
 }
 
-var getSeed = func() func() wallet.Seed {
-	var seed wallet.Seed
-	return func() wallet.Seed {
-		if seed == (wallet.Seed{}) {
-			phrase := os.Getenv("WALRUS_SEED")
-			if phrase != "" {
-				fmt.Println("Using WALRUS_SEED environment variable")
-			} else {
-				fmt.Print("Seed: ")
-				pw, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-				check(err, "Could not read seed phrase")
-				fmt.Println()
-				phrase = string(pw)
-			}
-			var err error
-			seed, err = wallet.SeedFromPhrase(phrase)
-			check(err, "Invalid seed")
+// parseOutputs parses a comma-separated list of addr:value or
+// addr:value:label outputs. The returned labels correspond to the returned
+// outputs; unlabeled outputs have an empty label.
+func parseOutputs(s string) ([]types.SiacoinOutput, []string) {
+	pairs := strings.Split(s, ",")
+	outputs := make([]types.SiacoinOutput, len(pairs))
+	labels := make([]string, len(pairs))
+	for i, p := range pairs {
+		addrAmount := strings.SplitN(p, ":", 3)
+		if len(addrAmount) < 2 {
+			check(errors.New("outputs must be specified in addr:amount pairs"), "Could not parse outputs")
+		}
+		var err error
+		outputs[i].UnlockHash, err = parseAddress(strings.TrimSpace(addrAmount[0]))
+		checkUsage(err, "Invalid destination address")
+		outputs[i].Value = parseAmount(addrAmount[1])
+		if len(addrAmount) == 3 {
+			labels[i] = strings.TrimSpace(addrAmount[2])
 		}
-		return seed
 	}
-}()
+	return outputs, labels
+}
 
-var getNanoS = func() func() *sialedger.NanoS {
-	var nanos *sialedger.NanoS
-	return func() *sialedger.NanoS {
-		if nanos == nil {
-			var err error
-			nanos, err = sialedger.OpenNanoS()
-			check(err, "Could not connect to Nano S")
+const editOutputsTemplate = `# Enter the outputs of the transaction, one address:value pair per line, where
+# value is specified in SC. A label may be appended as address:value:label.
+# Lines beginning with '#' are ignored, and an empty file aborts the
+# transaction.
+`
+
+// parseOutputLines parses outputs specified as address:value pairs, one per
+// line. Blank lines and lines beginning with '#' are ignored.
+func parseOutputLines(s string) ([]types.SiacoinOutput, []string) {
+	var pairs []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			pairs = append(pairs, line)
 		}
-		return nanos
 	}
-}()
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	return parseOutputs(strings.Join(pairs, ","))
+}
 
-func main() {
-	log.SetFlags(0)
-	var sign, broadcast bool // used by txn and sign commands
-	var changeAddrStr string // used by the txn and split commands
-	var showPubkey bool      // used by the addr command
+// editOutputsFlow writes outputs to a temporary file, opens it in $EDITOR, and
+// returns the outputs specified in the saved file. If $EDITOR is not set, the
+// user is asked to edit the file themselves.
+func editOutputsFlow(outputs []types.SiacoinOutput, labels []string) ([]types.SiacoinOutput, []string) {
+	f, err := ioutil.TempFile("", "walrus-outputs-*.txt")
+	check(err, "Could not create outputs file")
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, editOutputsTemplate)
+	for i, o := range outputs {
+		sc := new(big.Rat).SetFrac(o.Value.Big(), types.SiacoinPrecision.Big()).FloatString(24)
+		sc = strings.TrimSuffix(strings.TrimRight(sc, "0"), ".")
+		if i < len(labels) && labels[i] != "" {
+			sc += ":" + labels[i]
+		}
+		fmt.Fprintf(f, "%v:%v\n", o.UnlockHash, sc)
+	}
+	check(f.Close(), "Could not write outputs file")
 
-	rootCmd := flagg.Root
-	apiAddr := rootCmd.String("a", "http://localhost:9380", "host:port that the walrus API is running on")
-	ledger := rootCmd.Bool("ledger", false, "use a Ledger Nano S instead of a seed")
-	rootCmd.Usage = flagg.SimpleUsage(rootCmd, rootUsage)
-	versionCmd := flagg.New("version", versionUsage)
-	seedCmd := flagg.New("seed", seedUsage)
-	balanceCmd := flagg.New("balance", balanceUsage)
-	consensusCmd := flagg.New("consensus", consensusUsage)
-	addressesCmd := flagg.New("addresses", addressesUsage)
-	addrCmd := flagg.New("addr", addrUsage)
-	addrCmd.BoolVar(&showPubkey, "pubkey", false, "also display the address's public key")
-	txnCmd := flagg.New("txn", txnUsage)
-	txnCmd.BoolVar(&sign, "sign", false, "sign the transaction")
-	txnCmd.BoolVar(&broadcast, "broadcast", false, "broadcast the transaction")
-	txnCmd.StringVar(&changeAddrStr, "change", "", "use this change address instead of generating a new one")
-	splitCmd := flagg.New("split", splitUsage)
-	splitCmd.BoolVar(&sign, "sign", false, "sign the transaction")
-	splitCmd.BoolVar(&broadcast, "broadcast", false, "broadcast the transaction")
-	splitCmd.StringVar(&changeAddrStr, "change", "", "use this change address instead of generating a new one")
+	if editor := os.Getenv("EDITOR"); editor != "" {
+		cmd := exec.Command(editor, f.Name())
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		check(cmd.Run(), "Could not run editor")
+	} else {
+		fmt.Println("$EDITOR is not set. Please edit the outputs in the following file:")
+		fmt.Println("    " + f.Name())
+		fmt.Print("Press ENTER when you have saved your changes, or Ctrl-C to cancel.")
+		bufio.NewReader(os.Stdin).ReadLine()
+		fmt.Println()
+	}
+
+	js, err := ioutil.ReadFile(f.Name())
+	check(err, "Could not read outputs file")
+	outputs, labels = parseOutputLines(string(js))
+	if len(outputs) == 0 {
+		fatalf("No outputs specified; aborting.")
+	}
+	return outputs, labels
+}
+
+// maxOutputLabelsSize is the maximum size of the arbitrary data used to store
+// output labels.
+const maxOutputLabelsSize = 1024
+
+// outputLabelsPrefix identifies arbitrary data containing output labels. siad
+// only relays arbitrary data beginning with a recognized specifier, so the
+// "NonSia" specifier comes first.
+func outputLabelsPrefix() []byte {
+	nonSia := types.NewSpecifier("NonSia")
+	return append(nonSia[:], "walrus-labels"...)
+}
+
+// encodeOutputLabels encodes labels, keyed by output index, as arbitrary data.
+func encodeOutputLabels(labels map[uint64]string) []byte {
+	js, err := json.Marshal(labels)
+	check(err, "Could not encode output labels")
+	data := append(outputLabelsPrefix(), js...)
+	if len(data) > maxOutputLabelsSize {
+		check(fmt.Errorf("labels occupy %v bytes, exceeding the limit of %v", len(data), maxOutputLabelsSize), "Could not encode output labels")
+	}
+	return data
+}
+
+// decodeOutputLabels returns the output labels stored in txn's arbitrary
+// data, keyed by output index, or nil if txn contains no labels.
+func decodeOutputLabels(txn types.Transaction) map[uint64]string {
+	prefix := outputLabelsPrefix()
+	for _, data := range txn.ArbitraryData {
+		if !bytes.HasPrefix(data, prefix) {
+			continue
+		}
+		var labels map[uint64]string
+		if err := json.Unmarshal(data[len(prefix):], &labels); err == nil {
+			return labels
+		}
+	}
+	return nil
+}
+
+// matchOutputLabels assigns each non-empty labels[i], which was specified for
+// labeled[i], to the index of the matching output in outputs. This allows
+// labels to survive reordering of the outputs.
+func matchOutputLabels(outputs, labeled []types.SiacoinOutput, labels []string) map[uint64]string {
+	matched := make(map[uint64]string)
+	claimed := make([]bool, len(outputs))
+	for i, label := range labels {
+		if label == "" {
+			continue
+		}
+		for j, o := range outputs {
+			if !claimed[j] && o.UnlockHash == labeled[i].UnlockHash && o.Value.Cmp(labeled[i].Value) == 0 {
+				claimed[j] = true
+				matched[uint64(j)] = label
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// feeShares divides fee among outputs in proportion to their values. Each
+// share is rounded down, and the remainder is added to the share of the most
+// valuable output, so that the shares sum to exactly fee.
+func feeShares(fee types.Currency, outputs []types.SiacoinOutput) []types.Currency {
+	shares := make([]types.Currency, len(outputs))
+	var total types.Currency
+	for _, o := range outputs {
+		total = total.Add(o.Value)
+	}
+	if total.IsZero() {
+		return shares
+	}
+	var sum types.Currency
+	largest := 0
+	for i, o := range outputs {
+		shares[i] = fee.Mul(o.Value).Div(total)
+		sum = sum.Add(shares[i])
+		if o.Value.Cmp(outputs[largest].Value) > 0 {
+			largest = i
+		}
+	}
+	shares[largest] = shares[largest].Add(fee.Sub(sum))
+	return shares
+}
+
+// duplicateRecipients returns the addresses that appear more than once in
+// outputs, along with the combined value sent to each.
+func duplicateRecipients(outputs []types.SiacoinOutput) []types.SiacoinOutput {
+	counts := make(map[types.UnlockHash]int)
+	totals := make(map[types.UnlockHash]types.Currency)
+	for _, o := range outputs {
+		counts[o.UnlockHash]++
+		totals[o.UnlockHash] = totals[o.UnlockHash].Add(o.Value)
+	}
+	var dups []types.SiacoinOutput
+	for _, o := range outputs {
+		if counts[o.UnlockHash] > 1 {
+			dups = append(dups, types.SiacoinOutput{UnlockHash: o.UnlockHash, Value: totals[o.UnlockHash]})
+			counts[o.UnlockHash] = 0
+		}
+	}
+	return dups
+}
+
+func parseEqualSplit(s string) []types.SiacoinOutput {
+	amountAddrs := strings.SplitN(s, ":", 2)
+	if len(amountAddrs) != 2 {
+		check(errors.New("equal split must be specified as amount:addr1,addr2,..."), "Could not parse equal split")
+	}
+	value := parseAmount(amountAddrs[0])
+	addrs := strings.Split(amountAddrs[1], ",")
+	outputs := make([]types.SiacoinOutput, len(addrs))
+	for i, addr := range addrs {
+		var err error
+		outputs[i].UnlockHash, err = parseAddress(strings.TrimSpace(addr))
+		checkUsage(err, "Invalid destination address")
+		outputs[i].Value = value
+	}
+	return outputs
+}
+
+// shuffleOutputs randomly permutes outputs, so that the position of the change
+// output does not reveal which output is change.
+func shuffleOutputs(outputs []types.SiacoinOutput) {
+	for i := len(outputs) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		check(err, "Could not shuffle outputs")
+		k := j.Int64()
+		outputs[i], outputs[k] = outputs[k], outputs[i]
+	}
+}
+
+// sortOutputs orders outputs by value, ascending unless desc is true. Outputs
+// of equal value retain their relative order.
+func sortOutputs(outputs []types.SiacoinOutput, desc bool) {
+	sort.SliceStable(outputs, func(i, j int) bool {
+		if desc {
+			return outputs[i].Value.Cmp(outputs[j].Value) > 0
+		}
+		return outputs[i].Value.Cmp(outputs[j].Value) < 0
+	})
+}
+
+// parseKeyHints parses a comma-separated list of input:key index pairs.
+func parseKeyHints(s string) map[int]uint64 {
+	hints := make(map[int]uint64)
+	for _, p := range strings.Split(s, ",") {
+		inputKey := strings.Split(p, ":")
+		if len(inputKey) != 2 {
+			check(errors.New("key indices must be specified in input:key pairs"), "Could not parse key indices")
+		}
+		inputIndex, err := strconv.Atoi(strings.TrimSpace(inputKey[0]))
+		checkUsage(err, "Invalid input index")
+		keyIndex, err := strconv.ParseUint(strings.TrimSpace(inputKey[1]), 10, 32)
+		checkUsage(err, "Invalid key index")
+		if inputIndex < 0 {
+			checkUsage(errors.New("input index must not be negative"), "Invalid input index")
+		} else if _, ok := hints[inputIndex]; ok {
+			check(fmt.Errorf("input %v specified more than once", inputIndex), "Could not parse key indices")
+		}
+		hints[inputIndex] = keyIndex
+	}
+	return hints
+}
+
+// parseInputIndices parses a comma-separated list of input indices, each of
+// which must be less than numInputs.
+func parseInputIndices(s string, numInputs int) []int {
+	var indices []int
+	seen := make(map[int]bool)
+	for _, f := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(f))
+		checkUsage(err, "Invalid input index")
+		if i < 0 || i >= numInputs {
+			checkUsage(fmt.Errorf("input %v does not exist (transaction has %v input%v)", i, numInputs, plural(numInputs)), "Invalid input index")
+		} else if seen[i] {
+			checkUsage(fmt.Errorf("input %v specified more than once", i), "Invalid input index")
+		}
+		seen[i] = true
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// parseTimelock parses a timelocked output of the form height:pubkey:value,
+// returning the unlock conditions of the output and its value.
+func parseTimelock(s string) (types.UnlockConditions, types.Currency) {
+	heightRest := strings.SplitN(s, ":", 2)
+	sep := strings.LastIndexByte(s, ':')
+	if len(heightRest) != 2 || sep <= len(heightRest[0]) {
+		check(errors.New("timelocked output must be specified as height:pubkey:value"), "Could not parse timelock")
+	}
+	height, err := strconv.ParseUint(strings.TrimSpace(heightRest[0]), 10, 64)
+	checkUsage(err, "Invalid timelock height")
+	var pubkey types.SiaPublicKey
+	err = pubkey.LoadString(strings.TrimSpace(s[len(heightRest[0])+1 : sep]))
+	checkUsage(err, "Invalid public key")
+	uc := wallet.StandardUnlockConditions(pubkey)
+	uc.Timelock = types.BlockHeight(height)
+	return uc, parseCurrency(s[sep+1:])
+}
+
+// readTxnSet reads either a single transaction or a JSON array of
+// transactions from filename. Transactions in a set are returned in the order
+// they appear, which should be parents before children.
+func readTxnSet(filename string) []types.Transaction {
+	js, err := ioutil.ReadFile(filename)
+	check(err, "Could not read transaction file")
+	var txns []types.Transaction
+	if trimmed := bytes.TrimSpace(js); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(js, &txns)
+		check(err, "Could not parse transaction file")
+		if len(txns) == 0 {
+			check(errors.New("transaction set is empty"), "Could not parse transaction file")
+		}
+		return txns
+	}
+	var txn types.Transaction
+	err = json.Unmarshal(js, &txn)
+	check(err, "Could not parse transaction file")
+	return append(txns, txn)
+}
+
+// qrFramePrefix identifies a QR frame produced by qrFrames.
+const qrFramePrefix = "walrus-txn"
+
+// qrFrameSize is the number of payload bytes in each QR frame. It is small
+// enough for each frame to be scanned reliably from a screen.
+const qrFrameSize = 256
+
+// maxQRFrames is the maximum number of frames that parseQRFrames accepts,
+// bounding the memory allocated for an untrusted frame count.
+const maxQRFrames = 4096
+
+// qrFrames splits the JSON encoding of txns into frames of the form
+// walrus-txn:i/n:checksum:data, where data is base64-encoded and checksum
+// identifies the complete payload.
+func qrFrames(txns []types.Transaction) []string {
+	payload, _ := json.Marshal(txns)
+	sum := crypto.HashBytes(payload)
+	n := (len(payload) + qrFrameSize - 1) / qrFrameSize
+	frames := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		chunk := payload[i*qrFrameSize:]
+		if len(chunk) > qrFrameSize {
+			chunk = chunk[:qrFrameSize]
+		}
+		frames = append(frames, fmt.Sprintf("%v:%v/%v:%x:%v", qrFramePrefix, i+1, n, sum[:4], base64.StdEncoding.EncodeToString(chunk)))
+	}
+	return frames
+}
+
+// parseQRFrames reassembles the transactions encoded by qrFrames. The frames
+// may be in any order, and duplicates are ignored.
+func parseQRFrames(frames []string) ([]types.Transaction, error) {
+	var chunks [][]byte
+	var checksum string
+	for _, frame := range frames {
+		parts := strings.Split(frame, ":")
+		if len(parts) != 4 || parts[0] != qrFramePrefix {
+			return nil, fmt.Errorf("%q is not a walrus-cli QR frame", frame)
+		}
+		var i, n int
+		if _, err := fmt.Sscanf(parts[1], "%d/%d", &i, &n); err != nil || i < 1 || i > n {
+			return nil, fmt.Errorf("invalid frame index %q", parts[1])
+		} else if n > maxQRFrames {
+			return nil, fmt.Errorf("frame count %v exceeds the maximum of %v", n, maxQRFrames)
+		}
+		if chunks == nil {
+			chunks = make([][]byte, n)
+			checksum = parts[2]
+		} else if n != len(chunks) || parts[2] != checksum {
+			return nil, errors.New("frames belong to different transactions")
+		}
+		chunk, err := base64.StdEncoding.DecodeString(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid data in frame %v: %v", i, err)
+		}
+		chunks[i-1] = chunk
+	}
+	if chunks == nil {
+		return nil, errors.New("no frames found")
+	}
+	var missing []string
+	for i, chunk := range chunks {
+		if chunk == nil {
+			missing = append(missing, strconv.Itoa(i+1))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing frame%v %v of %v", plural(len(missing)), strings.Join(missing, ", "), len(chunks))
+	}
+	payload := bytes.Join(chunks, nil)
+	if sum := crypto.HashBytes(payload); fmt.Sprintf("%x", sum[:4]) != checksum {
+		return nil, errors.New("checksum mismatch; one or more frames were scanned incorrectly")
+	}
+	var txns []types.Transaction
+	if err := json.Unmarshal(payload, &txns); err != nil {
+		return nil, err
+	}
+	return txns, nil
+}
+
+// writeQRBundle writes the QR frames for txns to filename, one per line, and
+// returns the number of frames.
+func writeQRBundle(filename string, txns []types.Transaction) int {
+	frames := qrFrames(txns)
+	err := ioutil.WriteFile(filename, []byte(strings.Join(frames, "\n")+"\n"), 0666)
+	check(err, "Could not write QR frames")
+	return len(frames)
+}
+
+// readQRBundle reads a list of scanned QR frames from filename and
+// reassembles the transactions they encode.
+func readQRBundle(filename string) []types.Transaction {
+	data, err := ioutil.ReadFile(filename)
+	check(err, "Could not read QR frames")
+	var frames []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			frames = append(frames, line)
+		}
+	}
+	txns, err := parseQRFrames(frames)
+	check(err, "Could not reassemble QR frames")
+	return txns
+}
+
+func writeTxn(filename string, txn types.Transaction) {
+	js := encodeJSON(txn)
+	js = append(js, '\n')
+	err := ioutil.WriteFile(filename, js, 0666)
+	check(err, "Could not write transaction to disk")
+}
+
+// writeTxnSet writes txns to filename. A set containing one transaction is
+// written as a single object.
+func writeTxnSet(filename string, txns []types.Transaction) {
+	if len(txns) == 1 {
+		writeTxn(filename, txns[0])
+		return
+	}
+	js := encodeJSON(txns)
+	js = append(js, '\n')
+	err := ioutil.WriteFile(filename, js, 0666)
+	check(err, "Could not write transaction to disk")
+}
+
+// A receiptEntry describes one output of a transaction.
+type receiptEntry struct {
+	OutputIndex int                   `json:"outputIndex"`
+	OutputID    types.SiacoinOutputID `json:"outputID"`
+	Value       types.Currency        `json:"value"`
+	Kind        string                `json:"kind"`
+}
+
+// writeReceipt writes a JSON object to filename mapping each output address of
+// txn to the outputs it receives. The kind of each output is taken from kinds,
+// defaulting to "recipient".
+func writeReceipt(filename string, txn types.Transaction, kinds map[types.UnlockHash]string) {
+	receipt := make(map[string][]receiptEntry)
+	for i, o := range txn.SiacoinOutputs {
+		kind, ok := kinds[o.UnlockHash]
+		if !ok {
+			kind = "recipient"
+		}
+		addr := o.UnlockHash.String()
+		receipt[addr] = append(receipt[addr], receiptEntry{
+			OutputIndex: i,
+			OutputID:    txn.SiacoinOutputID(uint64(i)),
+			Value:       o.Value,
+			Kind:        kind,
+		})
+	}
+	js := encodeJSON(receipt)
+	js = append(js, '\n')
+	err := ioutil.WriteFile(filename, js, 0666)
+	check(err, "Could not write receipt")
+}
+
+// appendTxn adds txn to the end of the transaction set stored in filename,
+// creating the file if it does not exist. The set is always written as a JSON
+// array. It returns the number of transactions in the resulting set.
+func appendTxn(filename string, txn types.Transaction) int {
+	var txns []types.Transaction
+	if js, err := ioutil.ReadFile(filename); err == nil && len(bytes.TrimSpace(js)) > 0 {
+		txns = readTxnSet(filename)
+	} else if err != nil && !os.IsNotExist(err) {
+		check(err, "Could not read transaction file")
+	}
+	txns = append(txns, txn)
+	js := encodeJSON(txns)
+	js = append(js, '\n')
+	err := ioutil.WriteFile(filename, js, 0666)
+	check(err, "Could not write transaction to disk")
+	return len(txns)
+}
+
+func getDonationAddr(narwalAddr string) (types.UnlockHash, bool) {
+	u, err := url.Parse(narwalAddr)
+	if err != nil {
+		return types.UnlockHash{}, false
+	}
+	path := strings.Split(u.Path, "/")
+	if len(path) < 2 || path[len(path)-2] != "wallet" {
+		return types.UnlockHash{}, false
+	}
+	path = append(path[:len(path)-2], "donations")
+	u.Path = strings.Join(path, "/")
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return types.UnlockHash{}, false
+	}
+	defer resp.Body.Close()
+	defer ioutil.ReadAll(resp.Body)
+	var addr types.UnlockHash
+	err = json.NewDecoder(resp.Body).Decode(&addr)
+	return addr, err == nil
+}
+
+// validateAPIAddr returns an error if addr is not a valid walrus API address.
+func validateAPIAddr(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must begin with http:// or https://", addr)
+	} else if u.Host == "" {
+		return fmt.Errorf("%q does not specify a host", addr)
+	}
+	return nil
+}
+
+// parseProxy parses addr as the URL of a SOCKS5 or HTTP proxy.
+func parseProxy(addr string) (*url.URL, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "socks5h":
+		// the SOCKS5 dialer always lets the proxy resolve hostnames, so
+		// socks5h (commonly used for Tor) is equivalent to socks5
+		u.Scheme = "socks5"
+	case "socks5", "http", "https":
+	default:
+		return nil, fmt.Errorf("%q must begin with socks5://, socks5h://, http://, or https://", addr)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q does not specify a host", addr)
+	}
+	return u, nil
+}
+
+// flagSet reports whether the flag with the specified name was set on the
+// command line.
+func flagSet(fs *flag.FlagSet, name string) bool {
+	var set bool
+	fs.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// defaultTransport is the transport used by all HTTP requests, including those
+// made by the walrus client.
+var defaultTransport = http.DefaultTransport.(*http.Transport)
+
+// errOffline is returned for all network requests when -offline is set.
+var errOffline = errors.New("network access is disabled by -offline")
+
+// offlineTransport rejects every request, ensuring that no network calls are
+// made on an air-gapped machine.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errOffline
+}
+
+// refreshTransport adds a no-cache header to GET requests, so that the server
+// (or any intermediate cache) does not respond with stale data.
+type refreshTransport struct {
+	rt http.RoundTripper
+}
+
+func (t refreshTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet {
+		// RoundTrippers must not modify the original request
+		req = req.Clone(req.Context())
+		req.Header.Set("Cache-Control", "no-cache")
+		req.Header.Set("Pragma", "no-cache")
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// debugTransport logs each request and its raw response to stderr.
+type debugTransport struct {
+	rt http.RoundTripper
+}
+
+func (t debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := *req.URL
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	log.Printf("[debug] %v %v", req.Method, u.String())
+	for _, h := range []string{"Authorization", "Proxy-Authorization"} {
+		if req.Header.Get(h) != "" {
+			log.Printf("[debug] %v: REDACTED", h)
+		}
+	}
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		log.Printf("[debug] request failed: %v", err)
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	log.Printf("[debug] %v\n%s", resp.Status, body)
+	return resp, nil
+}
+
+// checkNetwork returns an error if network does not match the network that
+// this binary was built for.
+func checkNetwork(network string) error {
+	release := network
+	if network == "testnet" {
+		release = "testing"
+	}
+	switch release {
+	case "standard", "testing", "dev":
+	default:
+		return fmt.Errorf("unknown network %q (must be 'standard', 'testnet', or 'dev')", network)
+	}
+	if release != build.Release {
+		return fmt.Errorf("-network is %q, but this binary was built for the %q network", network, build.Release)
+	}
+	return nil
+}
+
+// The standard network's genesis timestamp and target block time, which
+// together predict the height of any standard-network server.
+const (
+	standardGenesisTimestamp = 1433600000
+	standardBlockFrequency   = 600
+)
+
+// checkServerNetwork returns an error if a server reporting the specified
+// height at time now is unlikely to be on network. The walrus API does not
+// identify the server's network, but the standard network's height stays close
+// to the height predicted by its genesis timestamp, whereas the test and dev
+// networks bear no relation to it.
+func checkServerNetwork(network string, height types.BlockHeight, now time.Time) error {
+	expected := types.BlockHeight(0)
+	if elapsed := now.Unix() - standardGenesisTimestamp; elapsed > 0 {
+		expected = types.BlockHeight(elapsed / standardBlockFrequency)
+	}
+	// allow for 10% drift from the target block time
+	onStandard := height >= expected-expected/10 && height <= expected+expected/10
+	if network == "standard" && !onStandard {
+		return fmt.Errorf("-network is %q, but the server's height (%v) is far from the standard network's (about %v)", network, height, expected)
+	} else if network != "standard" && onStandard {
+		return fmt.Errorf("-network is %q, but the server's height (%v) matches the standard network's", network, height)
+	}
+	return nil
+}
+
+// latestRelease queries releaseURL, which should respond like the GitHub
+// releases API, and returns the tag of the latest release.
+func latestRelease(releaseURL string) (string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(releaseURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	defer ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server responded with %v", resp.Status)
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", err
+	} else if release.TagName == "" {
+		return "", errors.New("response did not contain a release tag")
+	}
+	return release.TagName, nil
+}
+
+// minLedgerVersion is the oldest version of the Ledger Sia app known to work
+// with walrus-cli.
+const minLedgerVersion = "v0.4.0"
+
+// versionLess reports whether version a is older than version b. Versions are
+// dot-separated integers with an optional "v" prefix; unparseable components
+// are treated as zero.
+func versionLess(a, b string) bool {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for len(as) < len(bs) {
+		as = append(as, "0")
+	}
+	for len(bs) < len(as) {
+		bs = append(bs, "0")
+	}
+	for i := range as {
+		an, _ := strconv.Atoi(as[i])
+		bn, _ := strconv.Atoi(bs[i])
+		if an != bn {
+			return an < bn
+		}
+	}
+	return false
+}
+
+// A progress displays a spinner and counter on stderr while a long-running
+// loop executes. It does nothing if stderr is not a terminal or hideProgress is
+// set, so that it never pollutes redirected or machine-readable output.
+type progress struct {
+	msg     string
+	total   int
+	n       int
+	enabled bool
+}
+
+func newProgress(msg string, total int) *progress {
+	return &progress{
+		msg:     msg,
+		total:   total,
+		enabled: !state.hideProgress && terminal.IsTerminal(int(os.Stderr.Fd())),
+	}
+}
+
+// Inc advances the progress counter by one.
+func (p *progress) Inc() {
+	p.n++
+	if p.enabled {
+		const frames = `|/-\`
+		fmt.Fprintf(os.Stderr, "\r%c %v (%v/%v)", frames[p.n%len(frames)], p.msg, p.n, p.total)
+	}
+}
+
+// Done erases the progress display.
+func (p *progress) Done() {
+	if p.enabled && p.n > 0 {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
+
+// seedFile, if set, is the file that getSeed reads the seed phrase from.
+var seedFile string
+
+// An encryptedSeed is a seed phrase encrypted with XChaCha20-Poly1305, using a
+// key derived from a passphrase with scrypt.
+type encryptedSeed struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func seedKey(passphrase, salt []byte) []byte {
+	key, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, chacha20poly1305.KeySize)
+	check(err, "Could not derive encryption key")
+	return key
+}
+
+func encryptSeed(phrase string, passphrase []byte) encryptedSeed {
+	es := encryptedSeed{
+		Version: 1,
+		Salt:    make([]byte, 32),
+		Nonce:   make([]byte, chacha20poly1305.NonceSizeX),
+	}
+	_, err := rand.Read(es.Salt)
+	check(err, "Could not generate salt")
+	_, err = rand.Read(es.Nonce)
+	check(err, "Could not generate nonce")
+	aead, err := chacha20poly1305.NewX(seedKey(passphrase, es.Salt))
+	check(err, "Could not initialize cipher")
+	es.Ciphertext = aead.Seal(nil, es.Nonce, []byte(phrase), nil)
+	return es
+}
+
+func decryptSeed(es encryptedSeed, passphrase []byte) (string, error) {
+	if es.Version != 1 {
+		return "", fmt.Errorf("unsupported seed file version %v", es.Version)
+	} else if len(es.Nonce) != chacha20poly1305.NonceSizeX {
+		return "", errors.New("invalid nonce")
+	}
+	aead, err := chacha20poly1305.NewX(seedKey(passphrase, es.Salt))
+	if err != nil {
+		return "", err
+	}
+	phrase, err := aead.Open(nil, es.Nonce, es.Ciphertext, nil)
+	if err != nil {
+		return "", errors.New("incorrect passphrase")
+	}
+	return string(phrase), nil
+}
+
+// convertSeedPhrase parses s as either hex-encoded seed entropy or a seed
+// phrase, ignoring differences in case and whitespace.
+func convertSeedPhrase(s string) (wallet.Seed, error) {
+	s = strings.TrimSpace(s)
+	if b, err := hex.DecodeString(s); err == nil {
+		if len(b) != 16 {
+			return wallet.Seed{}, fmt.Errorf("entropy must be 16 bytes, got %v", len(b))
+		}
+		var entropy [16]byte
+		copy(entropy[:], b)
+		return wallet.SeedFromEntropy(entropy), nil
+	}
+	seed, err := wallet.SeedFromPhrase(strings.Join(strings.Fields(strings.ToLower(s)), " "))
+	if err != nil {
+		return wallet.Seed{}, fmt.Errorf("%v (only walrus seed phrases and hex-encoded entropy are supported)", err)
+	}
+	return seed, nil
+}
+
+// readSeedFile reads a seed phrase from filename, prompting for a passphrase
+// if the file is encrypted.
+func readSeedFile(filename string) string {
+	js, err := ioutil.ReadFile(filename)
+	check(err, "Could not read seed file")
+	if trimmed := bytes.TrimSpace(js); len(trimmed) == 0 || trimmed[0] != '{' {
+		return string(trimmed)
+	}
+	var es encryptedSeed
+	err = json.Unmarshal(js, &es)
+	check(err, "Could not parse seed file")
+	fmt.Fprint(infoOut(), "Passphrase: ")
+	pw, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	check(err, "Could not read passphrase")
+	fmt.Fprintln(infoOut())
+	phrase, err := decryptSeed(es, pw)
+	check(err, "Could not decrypt seed file")
+	return phrase
+}
+
+var getSeed = func() func() wallet.Seed {
+	var seed wallet.Seed
+	return func() wallet.Seed {
+		if seed == (wallet.Seed{}) {
+			phrase := os.Getenv("WALRUS_SEED")
+			if seedFile != "" {
+				fmt.Fprintln(infoOut(), "Using seed file", seedFile)
+				phrase = readSeedFile(seedFile)
+			} else if phrase != "" {
+				fmt.Fprintln(infoOut(), "Using WALRUS_SEED environment variable")
+			} else if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+				// stdin is a pipe; read the phrase without masking
+				if state.stdinUsed {
+					check(errors.New("stdin was already used to supply a value; set WALRUS_SEED or use -seed-file instead"), "Could not read seed phrase")
+				}
+				line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+				if err == io.EOF && line != "" {
+					err = nil
+				}
+				check(err, "Could not read seed phrase")
+				phrase = strings.TrimSpace(line)
+			} else {
+				fmt.Fprint(infoOut(), "Seed: ")
+				pw, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+				check(err, "Could not read seed phrase")
+				fmt.Fprintln(infoOut())
+				phrase = string(pw)
+			}
+			var err error
+			seed, err = wallet.SeedFromPhrase(phrase)
+			checkUsage(err, "Invalid seed")
+		}
+		return seed
+	}
+}()
+
+var getNanoS = func() func() *sialedger.NanoS {
+	var nanos *sialedger.NanoS
+	return func() *sialedger.NanoS {
+		if nanos == nil {
+			var err error
+			nanos, err = sialedger.OpenNanoS()
+			check(err, "Could not connect to Nano S")
+		}
+		return nanos
+	}
+}()
+
+func main() {
+	log.SetFlags(0)
+	run(nil)
+}
+
+// A rootConfig holds the settings given by the root flags, along with the
+// clients built from them. The commands of a batch file share the batch's
+// rootConfig rather than parsing root flags of their own.
+type rootConfig struct {
+	apiAddr string
+	ledger  bool
+	pretty  bool
+	feeCap  types.Currency
+	c, bc   *walrus.Client
+
+	// -network, and whether the server has been checked against it
+	network       string
+	networkErr    error
+	serverChecked bool
+
+	// package-level settings, which are reset whenever flags are defined
+	precision   int
+	verbose     bool
+	seedFile    string
+	jsonIndent  string
+	jsonErrors  bool
+	failOnReuse bool
+}
+
+// restore reinstates the package-level settings of rc.
+func (rc *rootConfig) restore() {
+	displayPrecision = rc.precision
+	verbose = rc.verbose
+	seedFile = rc.seedFile
+	jsonIndent = rc.jsonIndent
+	jsonErrors = rc.jsonErrors
+	failOnReuse = rc.failOnReuse
+}
+
+// A commandState holds the state of a single command, derived from its flags or
+// accumulated as it executes. run creates a new commandState for each command,
+// so that nothing leaks between the commands of a batch.
+type commandState struct {
+	// prettyPrint causes SC values to be displayed with thousands separators.
+	prettyPrint bool
+	// hideProgress suppresses progress displays, e.g. when -json output is
+	// being consumed by another program.
+	hideProgress bool
+	// addrNames maps addresses to their aliases, for display. It is nil
+	// unless -resolve is provided.
+	addrNames map[types.UnlockHash]string
+	// sigTimelock is the height before which the signatures added by the
+	// signing flows are invalid, preventing the transaction from being
+	// confirmed earlier.
+	sigTimelock types.BlockHeight
+	// parentOutputs, if non-nil, supplies the outputs spent by a transaction
+	// being signed, so that input values can be displayed without contacting
+	// the server.
+	parentOutputs map[types.SiacoinOutputID]parentOutput
+	// stdinUsed is set once a value has been read from stdin, after which
+	// stdin cannot also supply the seed phrase.
+	stdinUsed bool
+	// newChangeAddrs records the change addresses added to the wallet by
+	// getChangeFlow, so that they can be accounted for if the transaction
+	// that uses them is never broadcast.
+	newChangeAddrs []wallet.SeedAddressInfo
+}
+
+// state is the state of the executing command.
+var state = new(commandState)
+
+// run parses the command line in os.Args and executes the specified command.
+// If root is non-nil, the command is part of a batch, and root supplies the
+// settings and clients that would otherwise be derived from the root flags.
+func run(root *rootConfig) {
+	state = new(commandState)
+	var sign, broadcast bool  // used by txn and sign commands
+	var changeAddrStr string  // used by the txn and split commands
+	var showPubkey bool       // used by the addr command
+	var estimate bool         // used by the split command
+	var showTime bool         // used by the transactions command
+	var equalSplit string     // used by the txn command
+	var jsonOutput bool       // used by the overview, transactions, addr, and utxos commands
+	var randomizeOutputs bool // used by the txn and split commands
+	var noRegister bool       // used by the addr command
+	var fromAddrStr string    // used by the txn command
+	var checkVersion bool     // used by the version command
+	var releaseURL string     // used by the version command
+	var maxInputs int         // used by the txn, split, and defrag commands
+	var keyIndicesStr string  // used by the sign command
+	var inputsFile string     // used by the txn command
+	var timelockStr string    // used by the txn command
+	var verifyAddr bool       // used by the addr command
+	var addrSort string       // used by the addresses command
+	var txidStr string        // used by the transactions command
+	var changeStrategy string // used by the txn, split, and defrag commands
+	var spendUnconfirmed bool // used by the txn, split, and defrag commands
+	var gapScan bool          // used by the addr command
+	var gapLimit int          // used by the addr command
+	var dumpUnsigned string   // used by the txn, split, and defrag commands
+	var txnFormat string      // used by the transactions command
+	var previewN int          // used by the seed command
+	var appendTxns bool       // used by the txn and split commands
+	var minOutputStr string   // used by the txn command
+	var force bool            // used by the txn command
+	var sortOrder string      // used by the txn, split, and utxos commands
+	var noChange bool         // used by the txn command
+	var editOutputs bool      // used by the txn command
+	var confirmTotal bool     // used by the txn command
+	var selectStrategy string // used by the txn command
+	var encryptPath string    // used by the seed command
+	var showInputs bool       // used by the transactions command
+	var expectChange string   // used by the txn command
+	var dryRun bool           // used by the prune-addresses command
+	var inactiveBlocks int    // used by the prune-addresses command
+	var watch time.Duration   // used by the balance command
+	var onlyInputsStr string  // used by the sign command
+	var continueOnError bool  // used by the batch command
+	var convertSeed bool      // used by the seed command
+	var splitFee bool         // used by the txn command
+	var qrBundle string       // used by the txn command
+	var qrIn bool             // used by the sign command
+	var locktime uint64       // used by the txn and sign commands
+	var exportPath string     // used by the transactions command
+	var parentsPath string    // used by the sign command
+	var chunkSize int         // used by the broadcast command
+	var addrBalance bool      // used by the addr command
+	var exportFormat string   // used by the export-addresses command
+	var untrackOnFailure bool // used by the txn, split, and defrag commands
+	var receiptPath string    // used by the txn command
+	var sinceStr string       // used by the transactions command
+	var combineWith string    // used by the txn command
+	var showAll bool          // used by the transactions command
+	var summary bool          // used by the transactions command
+	var overwrite bool        // used by the alias import command
+	var dustAddrStr string    // used by the defrag command
+	var resolveAliases bool   // used by the decode and transactions commands
+	var estimateOnly bool     // used by the defrag command
+
+	rootCmd := flagg.Root
+	apiAddr := rootCmd.String("a", "http://localhost:9380", "host:port that the walrus API is running on")
+	ledger := rootCmd.Bool("ledger", false, "use a Ledger Nano S instead of a seed")
+	proxyAddr := rootCmd.String("proxy", os.Getenv("ALL_PROXY"), "proxy to route all requests through, e.g. socks5://127.0.0.1:9050 (defaults to $ALL_PROXY)")
+	broadcastTo := rootCmd.String("broadcast-to", "", "host:port of an alternate walrus API to broadcast transactions through")
+	rootCmd.IntVar(&displayPrecision, "precision", 30, "round displayed SC values to this many decimal places (does not affect transactions)")
+	pretty := rootCmd.Bool("pretty", false, "display SC values with thousands separators (ignored unless stdout is a terminal)")
+	offline := rootCmd.Bool("offline", false, "disable all network access; only seed, addr, sign, and decode may be used")
+	refresh := rootCmd.Bool("refresh", false, "ask the server not to serve cached data (e.g. balances and outputs)")
+	debug := rootCmd.Bool("debug", false, "log each API request and its raw response to stderr")
+	rootCmd.BoolVar(&verbose, "verbose", false, "display exact hastings alongside SC values")
+	network := rootCmd.String("network", "", "expected network ('standard', 'testnet', or 'dev'); commands refuse to run if it does not match this build or the server")
+	rootCmd.StringVar(&seedFile, "seed-file", "", "read the seed phrase from this file (which may be encrypted with 'seed -encrypt')")
+	rootCmd.StringVar(&jsonIndent, "indent", "  ", "indentation to use when writing JSON")
+	rootCmd.BoolVar(&jsonErrors, "json-errors", false, "report fatal errors on stderr as JSON objects")
+	rootCmd.BoolVar(&failOnReuse, "fail-on-reuse", false, "abort instead of warning whenever an address would be reused")
+	compact := rootCmd.Bool("compact", false, "write JSON without indentation")
+	feeCapStr := rootCmd.String("fee-cap", "100", "maximum total miner fee, in SC, for created transactions (0 for no limit)")
+	rootCmd.Usage = flagg.SimpleUsage(rootCmd, rootUsage)
+	versionCmd := flagg.New("version", versionUsage)
+	versionCmd.BoolVar(&checkVersion, "check", false, "check whether a newer release is available")
+	versionCmd.StringVar(&releaseURL, "release-url", "https://api.github.com/repos/lukechampine/walrus-cli/releases/latest", "URL to query for the latest release")
+	seedCmd := flagg.New("seed", seedUsage)
+	seedCmd.StringVar(&encryptPath, "encrypt", "", "encrypt an existing seed with a passphrase and write it to this file")
+	seedCmd.BoolVar(&convertSeed, "convert", false, "convert a seed phrase or hex entropy to the canonical phrase")
+	seedCmd.IntVar(&previewN, "preview", 0, "also display the first n addresses derived from the seed")
+	balanceCmd := flagg.New("balance", balanceUsage)
+	balanceCmd.DurationVar(&watch, "watch", 0, "poll the balance at this interval and report changes")
+	overviewCmd := flagg.New("overview", overviewUsage)
+	overviewCmd.BoolVar(&jsonOutput, "json", false, "print the summary as JSON")
+	consensusCmd := flagg.New("consensus", consensusUsage)
+	addressesCmd := flagg.New("addresses", addressesUsage)
+	addressesCmd.StringVar(&addrSort, "sort", "", "sort addresses by 'index' or 'addr'")
+	addrCmd := flagg.New("addr", addrUsage)
+	addrCmd.BoolVar(&showPubkey, "pubkey", false, "also display the address's public key")
+	addrCmd.BoolVar(&verifyAddr, "verify", false, "re-derive the address at the specified index and compare it to the server's")
+	addrCmd.BoolVar(&gapScan, "gap-scan", false, "scan the wallet's history for the next unused key index")
+	addrCmd.IntVar(&gapLimit, "gap-limit", 20, "number of consecutive unused addresses that ends a gap scan")
+	addrCmd.BoolVar(&jsonOutput, "json", false, "print the address and its derivation details as JSON")
+	addrCmd.BoolVar(&addrBalance, "balance", false, "report the balance of the address at the specified index (or of the specified address)")
+	addrCmd.BoolVar(&yes, "yes", false, "do not ask for confirmation when an index has likely been used before")
+	addrCmd.BoolVar(&noRegister, "no-register", false, "derive the address without contacting the server")
+	utxosCmd := flagg.New("utxos", utxosUsage)
+	utxosCmd.StringVar(&sortOrder, "sort", "", "sort outputs by value ('asc' or 'desc')")
+	utxosCmd.BoolVar(&jsonOutput, "json", false, "print outputs as JSON")
+	compareKeysCmd := flagg.New("compare-keys", compareKeysUsage)
+	watchBatchCmd := flagg.New("watch-batch", watchBatchUsage)
+	ledgerInfoCmd := flagg.New("ledger-info", ledgerInfoUsage)
+	feeMarketCmd := flagg.New("fee-market", feeMarketUsage)
+	cancelCmd := flagg.New("cancel", cancelUsage)
+	pruneAddressesCmd := flagg.New("prune-addresses", pruneAddressesUsage)
+	pruneAddressesCmd.BoolVar(&dryRun, "dry-run", false, "list the addresses that would be removed without removing them")
+	pruneAddressesCmd.IntVar(&inactiveBlocks, "inactive", 4320, "only remove addresses with no activity in this many blocks")
+	exportAddressesCmd := flagg.New("export-addresses", exportAddressesUsage)
+	exportAddressesCmd.StringVar(&exportFormat, "format", "json", "output format ('json' or 'csv')")
+	batchCmd := flagg.New("batch", batchUsage)
+	batchCmd.BoolVar(&continueOnError, "continue-on-error", false, "run the remaining commands even if one fails")
+	txnCmd := flagg.New("txn", txnUsage)
+	txnCmd.BoolVar(&sign, "sign", false, "sign the transaction")
+	txnCmd.BoolVar(&broadcast, "broadcast", false, "broadcast the transaction")
+	txnCmd.StringVar(&dumpUnsigned, "dump-unsigned", "", "write the unsigned transaction to this file before signing")
+	txnCmd.StringVar(&changeAddrStr, "change", "", "use this change address instead of generating a new one")
+	txnCmd.StringVar(&changeStrategy, "change-strategy", "new", "where to send change: 'new', 'reuse-smallest', or 'specified'")
+	txnCmd.DurationVar(&changeWindow, "change-reuse-window", 0, "reuse a change address generated within this duration instead of generating a new one")
+	txnCmd.IntVar(&maxInputs, "max-inputs", 0, "maximum number of inputs to spend (0 for no limit)")
+	txnCmd.BoolVar(&spendUnconfirmed, "spend-unconfirmed", false, "allow spending outputs created by unconfirmed transactions (if the parent transaction is never confirmed or is double-spent, this transaction will be invalid)")
+	txnCmd.BoolVar(&quiet, "quiet", false, "omit the summary and print informational output to stderr")
+	txnCmd.BoolVar(&untrackOnFailure, "untrack-on-failure", false, "if broadcasting fails, remove any newly-generated change address from the wallet")
+	txnCmd.StringVar(&timelockStr, "timelock", "", "add a timelocked output, specified as height:pubkey:value")
+	txnCmd.StringVar(&inputsFile, "inputs-file", "", "only spend the outputs whose IDs are listed in this file, one per line")
+	txnCmd.StringVar(&fromAddrStr, "from", "", "only spend outputs belonging to this address")
+	txnCmd.BoolVar(&randomizeOutputs, "randomize-outputs", false, "shuffle the order of the transaction's outputs")
+	txnCmd.StringVar(&sortOrder, "sort-outputs", "", "order the transaction's outputs by value ('asc' or 'desc')")
+	txnCmd.BoolVar(&appendTxns, "append", false, "append the transaction to the set in file instead of overwriting it")
+	txnCmd.StringVar(&minOutputStr, "min-output", "0.01", "reject recipient outputs worth less than this many SC")
+	txnCmd.BoolVar(&force, "force", false, "create the transaction even if an output is below -min-output")
+	txnCmd.Uint64Var(&locktime, "locktime", 0, "timelock the signatures so that the transaction cannot be confirmed before this height")
+	txnCmd.StringVar(&qrBundle, "qr-bundle", "", "also write the transaction to this file as a sequence of QR frame payloads, for rendering with an external tool")
+	txnCmd.BoolVar(&splitFee, "split-fee", false, "show each recipient's proportional share of the miner fee in the summary")
+	txnCmd.BoolVar(&yes, "yes", false, "do not ask for confirmation (e.g. for duplicate addresses or a large -no-change fee)")
+	txnCmd.BoolVar(&noChange, "no-change", false, "add any leftover value to the miner fee instead of creating a change output")
+	txnCmd.BoolVar(&editOutputs, "edit", false, "edit the outputs in $EDITOR before creating the transaction")
+	txnCmd.BoolVar(&confirmTotal, "confirm-total", false, "require the exact total sent to recipients to be retyped before signing and broadcasting")
+	txnCmd.StringVar(&selectStrategy, "select", "largest-first", "coin selection strategy: 'largest-first', 'smallest-first', or 'branch-and-bound'")
+	txnCmd.StringVar(&expectChange, "expect-change", "", "abort unless the change is within 0.001 SC of this many SC")
+	txnCmd.StringVar(&receiptPath, "receipt", "", "write a JSON receipt mapping each output's address to its index and value to this file")
+	txnCmd.StringVar(&combineWith, "combine-with", "", "also fund the transaction with outputs from the walrus server at this address")
+	txnCmd.StringVar(&equalSplit, "equal-split", "", "send the same amount to each address, specified as amount:addr1,addr2,...")
+	splitCmd := flagg.New("split", splitUsage)
+	splitCmd.BoolVar(&sign, "sign", false, "sign the transaction")
+	splitCmd.BoolVar(&broadcast, "broadcast", false, "broadcast the transaction")
+	splitCmd.StringVar(&dumpUnsigned, "dump-unsigned", "", "write the unsigned transaction to this file before signing")
+	splitCmd.StringVar(&changeAddrStr, "change", "", "use this change address instead of generating a new one")
+	splitCmd.StringVar(&changeStrategy, "change-strategy", "new", "where to send change: 'new', 'reuse-smallest', or 'specified'")
+	splitCmd.DurationVar(&changeWindow, "change-reuse-window", 0, "reuse a change address generated within this duration instead of generating a new one")
+	splitCmd.IntVar(&maxInputs, "max-inputs", 0, "maximum number of inputs to spend (0 for no limit)")
+	splitCmd.BoolVar(&spendUnconfirmed, "spend-unconfirmed", false, "allow spending outputs created by unconfirmed transactions (if the parent transaction is never confirmed or is double-spent, this transaction will be invalid)")
+	splitCmd.BoolVar(&quiet, "quiet", false, "omit the summary and print informational output to stderr")
+	splitCmd.BoolVar(&untrackOnFailure, "untrack-on-failure", false, "if broadcasting fails, remove any newly-generated change address from the wallet")
+	splitCmd.BoolVar(&randomizeOutputs, "randomize-outputs", false, "shuffle the order of the transaction's outputs")
+	splitCmd.StringVar(&sortOrder, "sort-outputs", "", "order the transaction's outputs by value ('asc' or 'desc')")
+	splitCmd.BoolVar(&appendTxns, "append", false, "append the transaction to the set in file instead of overwriting it")
+	splitCmd.BoolVar(&estimate, "estimate", false, "report the maximum number of outputs that can be funded")
+	splitCmd.BoolVar(&yes, "yes", false, "do not ask for confirmation (e.g. for a transaction without a miner fee)")
 	defragCmd := flagg.New("defrag", defragUsage)
 	defragCmd.BoolVar(&sign, "sign", false, "sign the transaction")
 	defragCmd.BoolVar(&broadcast, "broadcast", false, "broadcast the transaction")
+	defragCmd.StringVar(&dumpUnsigned, "dump-unsigned", "", "write the unsigned transaction to this file before signing")
 	defragCmd.StringVar(&changeAddrStr, "change", "", "use this change address instead of generating a new one")
+	defragCmd.StringVar(&changeStrategy, "change-strategy", "new", "where to send change: 'new', 'reuse-smallest', or 'specified'")
+	defragCmd.DurationVar(&changeWindow, "change-reuse-window", 0, "reuse a change address generated within this duration instead of generating a new one")
+	defragCmd.IntVar(&maxInputs, "max-inputs", 0, "maximum number of inputs to spend (0 for no limit)")
+	defragCmd.StringVar(&dustAddrStr, "dust", "", "sweep the outputs to this address instead of merging them into a wallet address")
+	defragCmd.BoolVar(&estimateOnly, "estimate-only", false, "print the input total, fee, and net amount without creating a transaction")
+	defragCmd.BoolVar(&spendUnconfirmed, "spend-unconfirmed", false, "allow spending outputs created by unconfirmed transactions (if the parent transaction is never confirmed or is double-spent, this transaction will be invalid)")
+	defragCmd.BoolVar(&quiet, "quiet", false, "omit the summary and print informational output to stderr")
+	defragCmd.BoolVar(&untrackOnFailure, "untrack-on-failure", false, "if broadcasting fails, remove any newly-generated change address from the wallet")
+	defragCmd.BoolVar(&yes, "yes", false, "do not ask for confirmation (e.g. for a transaction without a miner fee)")
 	signCmd := flagg.New("sign", signUsage)
 	signCmd.BoolVar(&broadcast, "broadcast", false, "broadcast the transaction (if true, omit file)")
+	signCmd.StringVar(&keyIndicesStr, "key-indices", "", "comma-separated input:key index pairs to sign, skipping server lookups")
+	signCmd.Uint64Var(&locktime, "locktime", 0, "timelock the signatures so that the transaction cannot be confirmed before this height")
+	signCmd.StringVar(&parentsPath, "parent-outputs", "", "read the values of the outputs being spent from this file (see 'utxos -json')")
+	signCmd.BoolVar(&qrIn, "qr-in", false, "read the transaction from a file of scanned QR frames")
+	signCmd.StringVar(&onlyInputsStr, "only-inputs", "", "comma-separated indices of the inputs to sign, leaving the rest for co-signers")
+	signCmd.BoolVar(&quiet, "quiet", false, "omit the summary and print informational output to stderr")
+	signCmd.BoolVar(&yes, "yes", false, "do not ask for confirmation (e.g. for a transaction without a miner fee)")
 	broadcastCmd := flagg.New("broadcast", broadcastUsage)
+	broadcastCmd.IntVar(&chunkSize, "chunk", 0, "broadcast the transaction set in batches of this many transactions (0 for all at once)")
+	broadcastCmd.BoolVar(&quiet, "quiet", false, "print informational output to stderr")
+	broadcastCmd.BoolVar(&yes, "yes", false, "do not ask for confirmation (e.g. for a transaction without a miner fee)")
+	decodeCmd := flagg.New("decode", decodeUsage)
+	decodeCmd.BoolVar(&resolveAliases, "resolve", false, "display addresses by their alias, if they have one")
 	transactionsCmd := flagg.New("transactions", transactionsUsage)
+	transactionsCmd.BoolVar(&showTime, "time", false, "display the estimated time of each transaction")
+	transactionsCmd.StringVar(&txnFormat, "format", "", "format each transaction with a Go template, or a preset ('default' or 'compact')")
+	transactionsCmd.BoolVar(&showInputs, "show-inputs", false, "list the wallet outputs spent by each transaction")
+	transactionsCmd.StringVar(&sinceStr, "since", "", "only display transactions confirmed after this block height or transaction ID")
+	transactionsCmd.StringVar(&exportPath, "export", "", "write the full transactions to this file as JSON")
+	transactionsCmd.BoolVar(&summary, "summary", false, "print the total inflow, outflow, and net change of the displayed transactions")
+	transactionsCmd.BoolVar(&showAll, "all", false, "include internal transactions that do not change the balance")
+	transactionsCmd.StringVar(&txidStr, "id", "", "display the full details of this transaction")
+	transactionsCmd.BoolVar(&jsonOutput, "json", false, "print transactions as JSON")
+	transactionsCmd.BoolVar(&resolveAliases, "resolve", false, "display addresses by their alias, if they have one")
+	mempoolCmd := flagg.New("mempool", mempoolUsage)
+	labelCmd := flagg.New("label", labelUsage)
+	labelSetCmd := flagg.New("set", labelSetUsage)
+	labelListCmd := flagg.New("list", labelListUsage)
+	aliasCmd := flagg.New("alias", aliasUsage)
+	aliasSetCmd := flagg.New("set", aliasSetUsage)
+	aliasListCmd := flagg.New("list", aliasListUsage)
+	aliasImportCmd := flagg.New("import", aliasImportUsage)
+	aliasImportCmd.BoolVar(&overwrite, "overwrite", false, "replace existing aliases that name a different address")
+	aliasExportCmd := flagg.New("export", aliasExportUsage)
+
+	cmd := flagg.Parse(flagg.Tree{
+		Cmd: rootCmd,
+		Sub: []flagg.Tree{
+			{Cmd: versionCmd},
+			{Cmd: seedCmd},
+			{Cmd: consensusCmd},
+			{Cmd: balanceCmd},
+			{Cmd: overviewCmd},
+			{Cmd: addressesCmd},
+			{Cmd: addrCmd},
+			{Cmd: utxosCmd},
+			{Cmd: compareKeysCmd},
+			{Cmd: watchBatchCmd},
+			{Cmd: ledgerInfoCmd},
+			{Cmd: feeMarketCmd},
+			{Cmd: cancelCmd},
+			{Cmd: pruneAddressesCmd},
+			{Cmd: exportAddressesCmd},
+			{Cmd: batchCmd},
+			{Cmd: txnCmd},
+			{Cmd: splitCmd},
+			{Cmd: defragCmd},
+			{Cmd: signCmd},
+			{Cmd: broadcastCmd},
+			{Cmd: decodeCmd},
+			{Cmd: transactionsCmd},
+			{Cmd: mempoolCmd},
+			{
+				Cmd: labelCmd,
+				Sub: []flagg.Tree{
+					{Cmd: labelSetCmd},
+					{Cmd: labelListCmd},
+				},
+			},
+			{
+				Cmd: aliasCmd,
+				Sub: []flagg.Tree{
+					{Cmd: aliasSetCmd},
+					{Cmd: aliasListCmd},
+					{Cmd: aliasImportCmd},
+					{Cmd: aliasExportCmd},
+				},
+			},
+		},
+	})
+	args := cmd.Args()
+
+	if root != nil {
+		root.restore()
+		if rootCmd.NFlag() > 0 {
+			checkUsage(errors.New("root flags must be passed to the batch command itself"), "Invalid batch command")
+		}
+	} else {
+		if *proxyAddr != "" {
+			// all HTTP requests, including those made by the walrus client, use
+			// the default transport
+			u, err := parseProxy(*proxyAddr)
+			if err != nil && !flagSet(rootCmd, "proxy") {
+				// an unusable $ALL_PROXY may be intended for other programs
+				log.Printf("Warning: ignoring $ALL_PROXY: %v", err)
+			} else {
+				checkUsage(err, "Invalid proxy address")
+				defaultTransport.Proxy = http.ProxyURL(u)
+			}
+		}
+		var rt http.RoundTripper = defaultTransport
+		if *refresh {
+			rt = refreshTransport{rt}
+		}
+		if *debug {
+			rt = debugTransport{rt}
+		}
+		if *offline {
+			rt = offlineTransport{}
+		}
+		http.DefaultTransport = rt
+		if displayPrecision < 0 {
+			checkUsage(errors.New("precision must not be negative"), "Invalid -precision value")
+		}
+		if *compact {
+			jsonIndent = ""
+		}
+		root = &rootConfig{
+			apiAddr:     *apiAddr,
+			ledger:      *ledger,
+			pretty:      *pretty,
+			feeCap:      parseCurrency(*feeCapStr),
+			c:           walrus.NewClient(*apiAddr),
+			precision:   displayPrecision,
+			verbose:     verbose,
+			seedFile:    seedFile,
+			jsonIndent:  jsonIndent,
+			jsonErrors:  jsonErrors,
+			failOnReuse: failOnReuse,
+		}
+		root.bc = root.c // used for broadcasting
+		if *broadcastTo != "" {
+			checkUsage(validateAPIAddr(*broadcastTo), "Invalid broadcast address")
+			root.bc = walrus.NewClient(*broadcastTo)
+		}
+		if *network != "" {
+			root.network = *network
+			root.networkErr = checkNetwork(*network)
+		}
+	}
+	c, bc, feeCap, networkErr := root.c, root.bc, root.feeCap, root.networkErr
+	state.sigTimelock = types.BlockHeight(locktime)
+	// keep machine-readable output unformatted
+	state.prettyPrint = root.pretty && !jsonOutput && terminal.IsTerminal(int(os.Stdout.Fd()))
+	state.hideProgress = jsonOutput
+	if resolveAliases {
+		state.addrNames = aliasNames(loadAliases())
+	}
+	if sortOrder != "" && sortOrder != "asc" && sortOrder != "desc" {
+		checkUsage(fmt.Errorf("unknown order %q (must be 'asc' or 'desc')", sortOrder), "Invalid sort order")
+	} else if sortOrder != "" && randomizeOutputs {
+		checkUsage(errors.New("-sort-outputs and -randomize-outputs are mutually exclusive"), "Invalid flags")
+	}
+	if combineWith != "" {
+		checkUsage(validateAPIAddr(combineWith), "Invalid -combine-with address")
+		if sign || broadcast {
+			checkUsage(errors.New("transactions funded by two wallets must be signed separately by each; omit -sign and -broadcast"), "Invalid flags")
+		}
+	}
+	switch selectStrategy {
+	case "largest-first", "smallest-first", "branch-and-bound":
+	default:
+		checkUsage(fmt.Errorf("unknown strategy %q", selectStrategy), "Invalid -select value")
+	}
+	if *offline {
+		switch cmd {
+		case rootCmd, versionCmd, seedCmd, decodeCmd:
+		case addrCmd:
+			if len(args) == 0 || gapScan {
+				check(errOffline, "Could not determine address index (specify one explicitly)")
+			} else if addrBalance || verifyAddr {
+				check(errOffline, "Could not query address")
+			}
+			noRegister = true
+		case signCmd:
+			if broadcast {
+				check(errOffline, "Could not broadcast transaction")
+			} else if keyIndicesStr == "" {
+				check(errOffline, "Could not look up key indices (specify them with -key-indices)")
+			}
+		default:
+			check(errOffline, "Could not run "+cmd.Name()+" command")
+		}
+	}
+	if networkErr != nil && cmd != rootCmd && cmd != versionCmd {
+		check(networkErr, "Network mismatch")
+	}
+	var needsServer bool
+	switch {
+	case *offline, noRegister, cmd == rootCmd, cmd == versionCmd, cmd == seedCmd, cmd == decodeCmd:
+		// these commands do not require the server (decode merely uses it
+		// if it is available)
+	default:
+		needsServer = true
+	}
+	if needsServer {
+		checkUsage(validateAPIAddr(root.apiAddr), "Invalid API address")
+	}
+	if needsServer && root.network != "" && !root.serverChecked {
+		info, err := c.ConsensusInfo()
+		check(err, "Could not get consensus info")
+		check(checkServerNetwork(root.network, info.Height, time.Now()), "Network mismatch")
+		root.serverChecked = true
+	}
+	if state.sigTimelock > 0 && broadcast {
+		info, err := c.ConsensusInfo()
+		check(err, "Could not get consensus info")
+		if info.Height+1 < state.sigTimelock {
+			check(fmt.Errorf("the transaction will not be valid until block %v (current height is %v)", state.sigTimelock, info.Height), "Cannot broadcast with -locktime")
+		}
+	}
+
+	switch cmd {
+	case rootCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		fallthrough
+	case versionCmd:
+		log.Printf("walrus-cli %s\nCommit:     %s\nRelease:    %s\nGo version: %s %s/%s\nBuild Date: %s\n",
+			version, githash, build.Release, runtime.Version(), runtime.GOOS, runtime.GOARCH, builddate)
+		if networkErr != nil {
+			log.Println("Warning:", networkErr)
+		}
+		if checkVersion {
+			latest, err := latestRelease(releaseURL)
+			if err != nil {
+				log.Println("Could not check for updates:", err)
+			} else if versionLess(version, latest) {
+				log.Printf("An update is available: %v (you have %v)", latest, version)
+			} else {
+				log.Println("You are running the latest release.")
+			}
+		}
+
+	case seedCmd:
+		if convertSeed {
+			if len(args) == 0 {
+				cmd.Usage()
+				return
+			}
+			seed, err := convertSeedPhrase(strings.Join(args, " "))
+			check(err, "Could not convert seed")
+			fmt.Println(seed)
+			fmt.Println()
+			fmt.Println("First address derived from this seed:")
+			fmt.Println("    " + wallet.StandardAddress(seed.PublicKey(0)).String())
+			return
+		} else if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		if encryptPath != "" {
+			phrase := getSeed().String()
+			fmt.Print("Passphrase: ")
+			pw, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+			check(err, "Could not read passphrase")
+			fmt.Print("\nConfirm passphrase: ")
+			confirm, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+			check(err, "Could not read passphrase")
+			fmt.Println()
+			if len(pw) == 0 {
+				fatalf("Passphrase must not be empty.")
+			} else if !bytes.Equal(pw, confirm) {
+				fatalf("Passphrases do not match.")
+			}
+			f, err := os.OpenFile(encryptPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			check(err, "Could not create seed file")
+			_, err = f.Write(append(encodeJSON(encryptSeed(phrase, pw)), '\n'))
+			if err == nil {
+				err = f.Close()
+			}
+			check(err, "Could not write seed file")
+			fmt.Println("Wrote encrypted seed to", encryptPath)
+			return
+		}
+		seed := wallet.NewSeed()
+		fmt.Println(seed)
+		if previewN > 0 {
+			fmt.Println()
+			fmt.Println("Derived addresses:")
+			for i := 0; i < previewN; i++ {
+				fmt.Printf("%6v  %v\n", i, wallet.StandardAddress(seed.PublicKey(uint64(i))))
+			}
+		}
+
+	case consensusCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		info, err := c.ConsensusInfo()
+		check(err, "Could not get consensus info")
+		fmt.Printf("Height:    %v\nChange ID: %v\n", info.Height, info.CCID)
+
+	case balanceCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		bal, err := c.Balance(true)
+		check(err, "Could not get balance")
+		if watch > 0 {
+			watchBalance(c, bal, watch)
+			return
+		}
+		if bal.IsZero() {
+			fmt.Println(currencyUnits(bal), "(wallet is empty)")
+			return
+		}
+		fmt.Println(currencyUnits(bal))
+
+	case overviewCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		ov, err := getOverview(c)
+		check(err, "Could not get wallet overview")
+		if jsonOutput {
+			js := encodeJSON(ov)
+			fmt.Println(string(js))
+			return
+		}
+		fmt.Printf("Balance:    %v\n", currencyUnits(ov.Balance))
+		switch ov.Pending.Cmp(ov.Balance) {
+		case 0:
+			fmt.Printf("Pending:    none\n")
+		case 1:
+			fmt.Printf("Pending:    +%v (balance after confirmation: %v)\n", currencyUnits(ov.Pending.Sub(ov.Balance)), currencyUnits(ov.Pending))
+		case -1:
+			fmt.Printf("Pending:    -%v (balance after confirmation: %v)\n", currencyUnits(ov.Balance.Sub(ov.Pending)), currencyUnits(ov.Pending))
+		}
+		fmt.Printf("UTXOs:      %v\n", ov.UTXOs)
+		fmt.Printf("Addresses:  %v\n", ov.Addresses)
+		fmt.Printf("Height:     %v\n", ov.Height)
+		fmt.Printf("Siafunds:   not reported by walrus\n")
+
+	case addressesCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		addrs, err := c.Addresses()
+		check(err, "Could not get address list")
+		if len(addrs) == 0 {
+			fmt.Println("No addresses.")
+			return
+		}
+		switch addrSort {
+		case "":
+			for _, addr := range addrs {
+				fmt.Println(addr)
+			}
+		case "addr":
+			sort.Slice(addrs, func(i, j int) bool {
+				return addrs[i].String() < addrs[j].String()
+			})
+			for _, addr := range addrs {
+				fmt.Println(addr)
+			}
+		case "index":
+			indices := make(map[types.UnlockHash]uint64, len(addrs))
+			for _, addr := range addrs {
+				info, err := c.AddressInfo(addr)
+				check(err, "Could not get address info")
+				indices[addr] = info.KeyIndex
+			}
+			sort.Slice(addrs, func(i, j int) bool {
+				return indices[addrs[i]] < indices[addrs[j]]
+			})
+			fmt.Println(" Index  Address")
+			for _, addr := range addrs {
+				fmt.Printf("%6v  %v\n", indices[addr], addr)
+			}
+		default:
+			check(fmt.Errorf("unknown sort order %q (must be 'index' or 'addr')", addrSort), "Could not list addresses")
+		}
+
+	case addrCmd:
+		if len(args) > 1 || ((noRegister || verifyAddr || addrBalance) && len(args) != 1) {
+			cmd.Usage()
+			return
+		}
+		if verifyAddr {
+			index, err := strconv.ParseUint(args[0], 10, 32)
+			checkUsage(err, "Invalid index")
+			verifyAddressFlow(c, index, root.ledger)
+			return
+		}
+		if addrBalance {
+			addr, err := parseAddress(args[0])
+			if err != nil {
+				index, err := strconv.ParseUint(args[0], 10, 32)
+				checkUsage(err, "Invalid index or address")
+				if root.ledger {
+					fmt.Fprintf(infoOut(), "Please accept the prompt on your device to generate address #%v.\n", index)
+					_, pubkey, err := getNanoS().GetAddress(uint32(index), false)
+					check(err, "Could not generate address")
+					addr = wallet.StandardAddress(pubkey)
+				} else {
+					addr = wallet.StandardAddress(getSeed().PublicKey(index))
+				}
+			}
+			addrBalanceFlow(c, addr)
+			return
+		}
+		if gapScan {
+			if len(args) != 0 {
+				cmd.Usage()
+				return
+			} else if root.ledger {
+				check(errors.New("gap scanning is not supported with -ledger"), "Could not scan addresses")
+			}
+			index := gapScanFlow(c, getSeed(), gapLimit)
+			fmt.Println("Next unused key index:", index)
+			return
+		}
+		if jsonOutput {
+			// keep stdout free for the JSON result
+			quiet = true
+		}
+		var index uint64
+		var err error
+		if len(args) == 0 {
+			index, err = c.SeedIndex()
+			check(err, "Could not get next seed index")
+			fmt.Fprintf(infoOut(), "No index specified; using lowest unused index (%v)\n", index)
+		} else {
+			index, err = strconv.ParseUint(args[0], 10, 32)
+			checkUsage(err, "Invalid index")
+			if !noRegister {
+				if next, err := c.SeedIndex(); err == nil && index < next {
+					warnReuse(fmt.Sprintf("Warning: index %v is below the lowest unused index (%v), so it has likely been used before.\nReusing addresses can compromise your privacy.", index, next))
+					if !yes {
+						fmt.Fprint(infoOut(), "Press ENTER to derive this address anyway, or Ctrl-C to cancel.")
+						bufio.NewReader(os.Stdin).ReadLine()
+					}
+				}
+			}
+		}
+		var pubkey types.SiaPublicKey
+		if root.ledger {
+			nanos := getNanoS()
+			fmt.Fprintf(infoOut(), "Please verify and accept the prompt on your device to generate address #%v.\n", index)
+			_, pubkey, err = nanos.GetAddress(uint32(index), false)
+			check(err, "Could not generate address")
+			fmt.Fprintln(infoOut(), "Compare the address displayed on your device to the address below:")
+			fmt.Fprintln(infoOut(), "    "+wallet.StandardAddress(pubkey).String())
+		} else {
+			seed := getSeed()
+			pubkey = seed.PublicKey(index)
+			fmt.Fprintln(infoOut(), "Derived address from seed:")
+			fmt.Fprintln(infoOut(), "    "+wallet.StandardAddress(pubkey).String())
+		}
+		if showPubkey {
+			fmt.Fprintln(infoOut(), "The pubkey for this address is:")
+			fmt.Fprintln(infoOut(), "    "+pubkey.String())
+		}
+
+		if noRegister {
+			fmt.Fprintln(infoOut(), "This address was not added to any wallet.")
+		} else if addrInfo, err := c.AddressInfo(wallet.StandardAddress(pubkey)); err == nil && addrInfo.KeyIndex == index {
+			warnReuse(`The server reported that it is already tracking this address. No further
+action is needed. Please be aware that reusing addresses can compromise
+your privacy.`)
+		} else {
+			fmt.Fprint(infoOut(), "Press ENTER to add this address to your wallet, or Ctrl-C to cancel.")
+			bufio.NewReader(os.Stdin).ReadLine()
+			err = c.AddAddress(wallet.SeedAddressInfo{
+				UnlockConditions: wallet.StandardUnlockConditions(pubkey),
+				KeyIndex:         index,
+			})
+			check(err, "Could not add address to wallet")
+			fmt.Fprintln(infoOut(), "Address added successfully.")
+		}
+		if jsonOutput {
+			js := encodeJSON(struct {
+				Address          types.UnlockHash       `json:"address"`
+				KeyIndex         uint64                 `json:"keyIndex"`
+				PublicKey        types.SiaPublicKey     `json:"publicKey"`
+				UnlockConditions types.UnlockConditions `json:"unlockConditions"`
+			}{wallet.StandardAddress(pubkey), index, pubkey, wallet.StandardUnlockConditions(pubkey)})
+			fmt.Println(string(js))
+		}
+
+	case utxosCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		utxos, err := c.UnspentOutputs(true)
+		check(err, "Could not get utxos")
+		if sortOrder != "" {
+			sort.SliceStable(utxos, func(i, j int) bool {
+				if sortOrder == "desc" {
+					return utxos[i].Value.Cmp(utxos[j].Value) > 0
+				}
+				return utxos[i].Value.Cmp(utxos[j].Value) < 0
+			})
+		}
+		type utxoEntry struct {
+			ID       types.SiacoinOutputID `json:"id"`
+			Value    types.Currency        `json:"value"`
+			Address  types.UnlockHash      `json:"address"`
+			KeyIndex uint64                `json:"keyIndex"`
+		}
+		entries := make([]utxoEntry, len(utxos))
+		keyIndices := make(map[types.UnlockHash]uint64)
+		var total types.Currency
+		p := newProgress("Resolving addresses", len(utxos))
+		for i, o := range utxos {
+			index, ok := keyIndices[o.UnlockHash]
+			if !ok {
+				info, err := c.AddressInfo(o.UnlockHash)
+				check(err, "Could not get address info")
+				index = info.KeyIndex
+				keyIndices[o.UnlockHash] = index
+			}
+			entries[i] = utxoEntry{o.ID, o.Value, o.UnlockHash, index}
+			total = total.Add(o.Value)
+			p.Inc()
+		}
+		p.Done()
+		if jsonOutput {
+			js := encodeJSON(entries)
+			fmt.Println(string(js))
+			return
+		}
+		if len(entries) == 0 {
+			fmt.Println("No unspent outputs.")
+			return
+		}
+		for _, e := range entries {
+			fmt.Printf("%v  %v  key %v  %v\n", e.ID, e.Address, e.KeyIndex, currencyUnits(e.Value))
+		}
+		fmt.Printf("Total: %v in %v output%v\n", currencyUnits(total), len(entries), plural(len(entries)))
+
+	case compareKeysCmd:
+		if len(args) != 2 {
+			cmd.Usage()
+			return
+		}
+		start, err := strconv.ParseUint(args[0], 10, 32)
+		checkUsage(err, "Invalid start index")
+		end, err := strconv.ParseUint(args[1], 10, 32)
+		checkUsage(err, "Invalid end index")
+		if end < start {
+			checkUsage(errors.New("end index must not be less than start index"), "Invalid index range")
+		}
+		seed := getSeed()
+		nanos := getNanoS()
+		mismatches := 0
+		for index := start; index <= end; index++ {
+			fmt.Printf("Please accept the prompt on your device to generate address #%v.\n", index)
+			_, pubkey, err := nanos.GetAddress(uint32(index), false)
+			check(err, "Could not generate address")
+			deviceAddr := wallet.StandardAddress(pubkey)
+			seedAddr := wallet.StandardAddress(seed.PublicKey(index))
+			if deviceAddr == seedAddr {
+				fmt.Printf("%6v  match     %v\n", index, seedAddr)
+			} else {
+				fmt.Printf("%6v  MISMATCH  seed: %v\n                  device: %v\n", index, seedAddr, deviceAddr)
+				mismatches++
+			}
+		}
+		if mismatches > 0 {
+			fatalf("%v of %v addresses did not match; the seed does not correspond to this device.", mismatches, end-start+1)
+		}
+		fmt.Println("All addresses match.")
+
+	case watchBatchCmd:
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		infos := readWatchFile(args[0])
+		var added, tracked int
+		for _, info := range infos {
+			addr := info.UnlockConditions.UnlockHash()
+			if existing, err := c.AddressInfo(addr); err == nil && existing.KeyIndex == info.KeyIndex {
+				fmt.Printf("%6v  %v  (already tracked)\n", info.KeyIndex, addr)
+				tracked++
+				continue
+			}
+			err := c.AddAddress(info)
+			check(err, fmt.Sprintf("Could not add address #%v to wallet", info.KeyIndex))
+			fmt.Printf("%6v  %v\n", info.KeyIndex, addr)
+			added++
+		}
+		fmt.Printf("Added: %v, already tracked: %v\n", added, tracked)
+
+	case ledgerInfoCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		appVersion, err := getNanoS().GetVersion()
+		check(err, "Could not get app version")
+		fmt.Println("Sia app version:", appVersion)
+		if versionLess(appVersion, minLedgerVersion) {
+			fmt.Printf("Warning: walrus-cli requires version %v or later; please update the Sia app using Ledger Live.\n", minLedgerVersion)
+		}
+
+	case feeMarketCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		feePerByte, err := c.RecommendedFee()
+		check(err, "Could not get recommended transaction fee")
+		fmt.Printf("Recommended fee: %v/byte\n", currencyUnits(feePerByte))
+		fmt.Println()
+		fmt.Println("Inputs  Outputs  Size (bytes)  Fee")
+		for _, shape := range [][2]int{{1, 1}, {1, 2}, {2, 2}, {5, 2}, {20, 1}} {
+			size := estimateTxnSize(shape[0], shape[1])
+			fmt.Printf("%6v  %7v  %12v  %v\n", shape[0], shape[1], size, currencyUnits(feePerByte.Mul64(uint64(size))))
+		}
+		fmt.Println()
+		fmt.Println("Note: the server does not report recent block fees, so low/median/high rates")
+		fmt.Println("and confirmation time estimates are unavailable.")
+
+	case cancelCmd:
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		orig := readTxnSet(args[0])
+		if len(orig) != 1 {
+			check(errors.New("file contains a transaction set; cancel each transaction separately"), "Could not cancel transaction")
+		}
+		if txn, err := c.Transaction(orig[0].ID()); err == nil && txn.BlockHeight != 0 {
+			check(fmt.Errorf("transaction was confirmed at height %v", txn.BlockHeight), "Could not cancel transaction")
+		}
+		values := walletOutputValues(c)
+		var inputSum, origFee types.Currency
+		inputs := make([]types.SiacoinInput, len(orig[0].SiacoinInputs))
+		for i, sci := range orig[0].SiacoinInputs {
+			v, ok := values[sci.ParentID]
+			if !ok {
+				check(fmt.Errorf("input %v does not spend a wallet output", i), "Could not cancel transaction")
+			}
+			inputSum = inputSum.Add(v)
+			inputs[i] = types.SiacoinInput{
+				ParentID:         sci.ParentID,
+				UnlockConditions: sci.UnlockConditions,
+			}
+		}
+		for _, fee := range orig[0].MinerFees {
+			origFee = origFee.Add(fee)
+		}
+		// pay at least double the original fee, and at least the recommended rate
+		feePerByte, err := c.RecommendedFee()
+		check(err, "Could not get recommended transaction fee")
+		fee := origFee.Mul64(2)
+		if rec := feePerByte.Mul64(uint64(estimateTxnSize(len(inputs), 1))); fee.Cmp(rec) < 0 {
+			fee = rec
+		}
+		if inputSum.Cmp(fee) <= 0 {
+			check(fundsError{"inputs are not worth enough to pay the higher fee"}, "Could not cancel transaction")
+		}
+		fmt.Println("Warning: Sia does not support replacing transactions. This cancellation only")
+		fmt.Println("succeeds if miners confirm the new transaction before the original one.")
+		fmt.Println()
+		changeAddr := getChangeFlow(c, root.ledger)
+		txn := types.Transaction{
+			SiacoinInputs: inputs,
+			SiacoinOutputs: []types.SiacoinOutput{{
+				UnlockHash: changeAddr,
+				Value:      inputSum.Sub(fee),
+			}},
+			MinerFees: []types.Currency{fee},
+		}
+		checkFeeCap(txn, feeCap)
+		fmt.Println("Cancellation summary:")
+		fmt.Printf("- %v input%v, totalling %v\n", len(inputs), plural(len(inputs)), currencyUnits(inputSum))
+		fmt.Printf("- A miner fee of %v (the original fee was %v)\n", currencyUnits(fee), currencyUnits(origFee))
+		fmt.Printf("- An output sending %v back to your wallet\n", currencyUnits(inputSum.Sub(fee)))
+		fmt.Println()
+		fmt.Print("Press ENTER to sign and broadcast the cancellation, or Ctrl-C to abort.")
+		bufio.NewReader(os.Stdin).ReadLine()
+		fmt.Println()
+		if root.ledger {
+			err = signFlowCold(c, &txn, nil)
+		} else {
+			err = signFlowHot(c, &txn, nil)
+		}
+		checkSigning(err, "Could not sign transaction")
+		err = broadcastFlow(bc, txn)
+		checkBroadcast(c, err, untrackOnFailure)
+
+	case pruneAddressesCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		info, err := c.ConsensusInfo()
+		check(err, "Could not get consensus info")
+		addrs, err := c.Addresses()
+		check(err, "Could not get address list")
+		utxos, err := c.UnspentOutputs(true)
+		check(err, "Could not get utxos")
+		_, txns := fetchTransactions(c)
+		prunable := prunableAddresses(addrs, utxos, txns, info.Height, types.BlockHeight(inactiveBlocks))
+		if len(prunable) == 0 {
+			fmt.Println("No addresses to prune.")
+			return
+		}
+		fmt.Println("Addresses to remove:")
+		for _, addr := range prunable {
+			fmt.Println("    " + addr.String())
+		}
+		fmt.Println("Total:", len(prunable))
+		if dryRun {
+			return
+		}
+		fmt.Print("Press ENTER to remove these addresses from your wallet, or Ctrl-C to cancel.")
+		bufio.NewReader(os.Stdin).ReadLine()
+		p := newProgress("Removing addresses", len(prunable))
+		for _, addr := range prunable {
+			err := c.RemoveAddress(addr)
+			check(err, "Could not remove address "+addr.String())
+			p.Inc()
+		}
+		p.Done()
+		fmt.Println("Addresses removed:", len(prunable))
+
+	case exportAddressesCmd:
+		if len(args) != 3 {
+			cmd.Usage()
+			return
+		} else if exportFormat != "json" && exportFormat != "csv" {
+			checkUsage(fmt.Errorf("unknown format %q", exportFormat), "Invalid -format")
+		}
+		start, err := strconv.ParseUint(args[0], 10, 32)
+		checkUsage(err, "Invalid start index")
+		end, err := strconv.ParseUint(args[1], 10, 32)
+		checkUsage(err, "Invalid end index")
+		if end < start {
+			checkUsage(errors.New("end index must not be less than start index"), "Invalid index range")
+		}
+		var infos []wallet.SeedAddressInfo
+		if root.ledger {
+			nanos := getNanoS()
+			for index := start; index <= end; index++ {
+				fmt.Printf("Please verify and accept the prompt on your device to generate address #%v.\n", index)
+				_, pubkey, err := nanos.GetAddress(uint32(index), false)
+				check(err, "Could not generate address")
+				fmt.Printf("%6v  %v\n", index, wallet.StandardAddress(pubkey))
+				infos = append(infos, wallet.SeedAddressInfo{
+					UnlockConditions: wallet.StandardUnlockConditions(pubkey),
+					KeyIndex:         index,
+				})
+			}
+		} else {
+			seed := getSeed()
+			for index := start; index <= end; index++ {
+				infos = append(infos, wallet.SeedAddressInfo{
+					UnlockConditions: wallet.StandardUnlockConditions(seed.PublicKey(index)),
+					KeyIndex:         index,
+				})
+			}
+			fmt.Printf("Derived %v addresses from seed.\n", len(infos))
+		}
+		fmt.Print("Press ENTER to add these addresses to your wallet, or Ctrl-C to cancel.")
+		bufio.NewReader(os.Stdin).ReadLine()
+		p := newProgress("Adding addresses", len(infos))
+		for _, info := range infos {
+			if existing, err := c.AddressInfo(info.UnlockConditions.UnlockHash()); err != nil || existing.KeyIndex != info.KeyIndex {
+				err := c.AddAddress(info)
+				check(err, fmt.Sprintf("Could not add address #%v to wallet", info.KeyIndex))
+			}
+			p.Inc()
+		}
+		p.Done()
+		err = writeAddressExport(args[2], exportFormat, infos)
+		check(err, "Could not write addresses")
+		fmt.Printf("Wrote %v addresses to %v.\n", len(infos), args[2])
+
+	case batchCmd:
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		} else if inBatch {
+			check(errors.New("batch files cannot run the batch command"), "Could not run batch")
+		}
+		runBatch(root, args[0], continueOnError)
+
+	case txnCmd:
+		if (equalSplit != "" || timelockStr != "" || editOutputs) && ((len(args) == 1 && !broadcast) || (len(args) == 0 && broadcast)) {
+			// outputs may be omitted when using -equal-split, -timelock, or -edit
+			args = append([]string{""}, args...)
+		}
+		if !((len(args) == 2) || (len(args) == 1 && broadcast)) {
+			cmd.Usage()
+			return
+		}
+		// parse outputs
+		var outputs []types.SiacoinOutput
+		var outputLabels []string
+		if args[0] != "" {
+			outputs, outputLabels = parseOutputs(args[0])
+		}
+		if editOutputs {
+			outputs, outputLabels = editOutputsFlow(outputs, outputLabels)
+		}
+		labeled := append([]types.SiacoinOutput(nil), outputs...)
+		var labelData []byte // used to estimate the fee; indices may change
+		if initial := matchOutputLabels(labeled, labeled, outputLabels); len(initial) > 0 {
+			labelData = encodeOutputLabels(initial)
+		}
+		if equalSplit != "" {
+			outputs = append(outputs, parseEqualSplit(equalSplit)...)
+		}
+		var timelockUC types.UnlockConditions
+		var timelockValue types.Currency
+		if timelockStr != "" {
+			timelockUC, timelockValue = parseTimelock(timelockStr)
+			outputs = append(outputs, types.SiacoinOutput{
+				UnlockHash: timelockUC.UnlockHash(),
+				Value:      timelockValue,
+			})
+		}
+		if minOutput := parseCurrency(minOutputStr); !force {
+			for _, o := range outputs {
+				if o.Value.Cmp(minOutput) < 0 {
+					check(fmt.Errorf("output of %v to %v is below the minimum of %v; use -force to send it anyway", currencyUnits(o.Value), o.UnlockHash, currencyUnits(minOutput)), "Could not create transaction")
+				}
+			}
+		}
+		if dups := duplicateRecipients(outputs); len(dups) > 0 && !yes {
+			fmt.Fprintln(infoOut(), "Warning: the following addresses are listed more than once:")
+			for _, o := range dups {
+				fmt.Fprintf(infoOut(), "    %v (%v in total)\n", o.UnlockHash, currencyUnits(o.Value))
+			}
+			fmt.Fprint(infoOut(), "Press ENTER to send multiple payments to these addresses, or Ctrl-C to cancel.")
+			bufio.NewReader(os.Stdin).ReadLine()
+			fmt.Fprintln(infoOut())
+		}
+		numRecipients := len(outputs)
+		recipients := append([]types.SiacoinOutput(nil), outputs...)
+		var recipSum types.Currency
+		for _, o := range outputs {
+			recipSum = recipSum.Add(o.Value)
+		}
+
+		// if using a narwal server, compute donation
+		var donation types.Currency
+		donationAddr, ok := getDonationAddr(root.apiAddr)
+		if ok {
+			// donation is max(1%, 10SC)
+			donation = recipSum.MulRat(big.NewRat(1, 100))
+			if tenSC := types.SiacoinPrecision.Mul64(10); donation.Cmp(tenSC) < 0 {
+				donation = tenSC
+			}
+		}
+
+		// fund transaction
+		utxos, err := spendableOutputs(c, spendUnconfirmed)
+		check(err, "Could not get utxos")
+		var cc *walrus.Client // combined wallet, if any
+		combined := make(map[types.SiacoinOutputID]bool)
+		if combineWith != "" {
+			cc = walrus.NewClient(combineWith)
+			ccUTXOs, err := spendableOutputs(cc, spendUnconfirmed)
+			check(err, "Could not get utxos from combined wallet")
+			for _, o := range ccUTXOs {
+				combined[o.ID] = true
+			}
+			utxos = append(utxos, ccUTXOs...)
+		}
+		checkNonEmpty(utxos)
+		if fromAddrStr != "" {
+			fromAddr, err := parseAddress(fromAddrStr)
+			check(err, "Could not parse funding address")
+			utxos = filterByAddress(utxos, fromAddr)
+		}
+		if inputsFile != "" {
+			utxos = filterByID(utxos, readOutputIDs(inputsFile))
+		}
+		capped := maxInputs > 0 && len(utxos) > maxInputs
+		if capped {
+			utxos = largestOutputs(utxos, maxInputs)
+		}
+		inputs := make([]wallet.ValuedInput, len(utxos))
+		p := newProgress("Resolving inputs", len(utxos))
+		for i, o := range utxos {
+			client := c
+			if combined[o.ID] {
+				client = cc
+			}
+			info, err := client.AddressInfo(o.UnlockHash)
+			check(err, "Could not get address info")
+			inputs[i] = wallet.ValuedInput{
+				SiacoinInput: types.SiacoinInput{
+					ParentID:         o.ID,
+					UnlockConditions: info.UnlockConditions,
+				},
+				Value: o.Value,
+			}
+			p.Inc()
+		}
+		p.Done()
+		feePerByte, err := c.RecommendedFee()
+		check(err, "Could not get recommended transaction fee")
+		// pay for the arbitrary data holding the output labels
+		labelFee := feePerByte.Mul64(uint64(len(labelData)))
+		inputs = selectInputs(selectStrategy, inputs, outputs, recipSum.Add(donation).Add(labelFee), feePerByte)
+		used, fee, change, ok := wallet.FundTransaction(recipSum.Add(donation).Add(labelFee), feePerByte, inputs)
+		if !ok {
+			// couldn't afford transaction with donation; try funding without
+			// donation and "donate the change" instead
+			used, fee, change, ok = wallet.FundTransaction(recipSum.Add(labelFee), feePerByte, inputs)
+			if !ok && capped {
+				check(fundsError{fmt.Sprintf("insufficient funds using at most %v inputs; consider consolidating your outputs with the 'defrag' command first", maxInputs)}, "Could not create transaction")
+			} else if !ok && fromAddrStr != "" {
+				check(fundsError{fmt.Sprintf("insufficient funds in address %v", fromAddrStr)}, "Could not create transaction")
+			} else if !ok {
+				check(fundsError{"insufficient funds"}, "Could not create transaction")
+			}
+			donation, change = change, types.ZeroCurrency
+		}
+		fee = fee.Add(labelFee)
+		if !donation.IsZero() {
+			outputs = append(outputs, types.SiacoinOutput{
+				UnlockHash: donationAddr,
+				Value:      donation,
+			})
+		}
+
+		// if requested, or if the change would cost more to spend than it is
+		// worth, give the change to the miners instead
+		var extraFee types.Currency
+		if dust := dustThreshold(feePerByte); !change.IsZero() && change.Cmp(dust) < 0 {
+			fmt.Fprintf(infoOut(), "The change (%v) is worth less than the cost of spending it, so it will be added to the miner fee.\n", currencyUnits(change))
+			extraFee, change = change, types.ZeroCurrency
+		}
+		if noChange && !change.IsZero() {
+			extraFee, change = change, types.ZeroCurrency
+			if extraFee.Cmp(types.SiacoinPrecision) > 0 && !yes {
+				fmt.Fprintf(infoOut(), "Warning: -no-change will add %v of leftover value to the miner fee.\n", currencyUnits(extraFee))
+				fmt.Fprint(infoOut(), "Press ENTER to give this value to the miners, or Ctrl-C to cancel.")
+				bufio.NewReader(os.Stdin).ReadLine()
+				fmt.Fprintln(infoOut())
+			}
+		}
+
+		// add change (if there is any)
+		var changeAddr types.UnlockHash
+		if !change.IsZero() {
+			smallest := used[0]
+			for _, in := range used[1:] {
+				if in.Value.Cmp(smallest.Value) < 0 {
+					smallest = in
+				}
+			}
+			changeAddr = getChangeAddr(c, changeStrategy, changeAddrStr, smallest.UnlockConditions.UnlockHash(), root.ledger)
+			outputs = append(outputs, types.SiacoinOutput{
+				Value:      change,
+				UnlockHash: changeAddr,
+			})
+		}
+		txn := types.Transaction{
+			SiacoinInputs:  make([]types.SiacoinInput, len(used)),
+			SiacoinOutputs: outputs,
+			MinerFees:      []types.Currency{fee.Add(extraFee)},
+		}
+		var inputSum types.Currency
+		for i, in := range used {
+			txn.SiacoinInputs[i] = in.SiacoinInput
+			inputSum = inputSum.Add(in.Value)
+		}
+		if randomizeOutputs {
+			shuffleOutputs(txn.SiacoinOutputs)
+		} else if sortOrder != "" {
+			sortOutputs(txn.SiacoinOutputs, sortOrder == "desc")
+		}
+		if len(labelData) > 0 {
+			txn.ArbitraryData = [][]byte{encodeOutputLabels(matchOutputLabels(txn.SiacoinOutputs, labeled, outputLabels))}
+		}
+		// the fee was estimated before the donation and change outputs were
+		// added; if the final transaction is larger, pay for the difference
+		// out of the change
+		if required := feePerByte.Mul64(uint64(estimateSignedSize(txn))); required.Cmp(txn.MinerFees[0]) > 0 {
+			shortfall := required.Sub(txn.MinerFees[0])
+			if i := changeOutputIndex(txn, changeAddr, change); i >= 0 && change.Cmp(shortfall) > 0 {
+				change = change.Sub(shortfall)
+				fee = fee.Add(shortfall)
+				txn.SiacoinOutputs[i].Value = change
+				txn.MinerFees[0] = fee.Add(extraFee)
+			} else {
+				fmt.Fprintf(infoOut(), "Warning: the miner fee is %v less than the recommended rate for this transaction's size.\n", currencyUnits(shortfall))
+			}
+		}
+		// check the change only once its final value is known
+		if expectChange != "" {
+			expected := parseCurrency(expectChange)
+			var diff types.Currency
+			if change.Cmp(expected) < 0 {
+				diff = expected.Sub(change)
+			} else {
+				diff = change.Sub(expected)
+			}
+			if diff.Cmp(types.SiacoinPrecision.Div64(1000)) > 0 {
+				check(fmt.Errorf("change is %v, but %v was expected", currencyUnits(change), currencyUnits(expected)), "Could not create transaction")
+			}
+		}
+		checkFeeCap(txn, feeCap)
+		if receiptPath != "" {
+			kinds := make(map[types.UnlockHash]string)
+			if !change.IsZero() {
+				kinds[changeAddr] = "change"
+			}
+			if !donation.IsZero() {
+				kinds[donationAddr] = "donation"
+			}
+			if timelockStr != "" {
+				kinds[timelockUC.UnlockHash()] = "timelock"
+			}
+			writeReceipt(receiptPath, txn, kinds)
+			fmt.Fprintln(infoOut(), "Wrote receipt to", receiptPath)
+		}
+		if !quiet {
+			fmt.Println("Transaction summary:")
+			fmt.Printf("- %v input%v, totalling %v (selected %v)\n", len(used), plural(len(used)), currencyUnits(inputSum), selectStrategy)
+			if confirmTotal {
+				fmt.Printf("- %v recipient%v, totalling exactly %v SC\n", numRecipients, plural(numRecipients), exactUnits(recipSum))
+			} else {
+				fmt.Printf("- %v recipient%v, totalling %v\n", numRecipients, plural(numRecipients), currencyUnits(recipSum))
+			}
+			if timelockStr != "" {
+				fmt.Printf("- A timelocked output, sending %v to %v, which cannot be spent until block %v\n",
+					currencyUnits(timelockValue), timelockUC.UnlockHash(), timelockUC.Timelock)
+			}
+			if state.sigTimelock > 0 && sign {
+				fmt.Printf("- Signatures timelocked to block %v, so the transaction cannot be confirmed before then\n", state.sigTimelock)
+			} else if state.sigTimelock > 0 {
+				fmt.Printf("- No signatures yet; pass -locktime %v to the sign command to prevent confirmation before block %v\n", state.sigTimelock, state.sigTimelock)
+			}
+			if !donation.IsZero() {
+				fmt.Printf("- A donation of %v to the narwal server\n", currencyUnits(donation))
+			}
+			fmt.Printf("- A miner fee of %v, which is %v/byte\n", currencyUnits(fee), currencyUnits(feePerByte))
+			if !extraFee.IsZero() {
+				fmt.Printf("- An additional miner fee of %v, in place of a change output\n", currencyUnits(extraFee))
+			}
+			if !change.IsZero() {
+				fmt.Printf("- A change output, sending %v back to your wallet\n", currencyUnits(change))
+			}
+			if splitFee {
+				fmt.Println("- Each recipient's share of the miner fee:")
+				for i, share := range feeShares(fee.Add(extraFee), recipients) {
+					fmt.Printf("    %v: %v\n", recipients[i].UnlockHash, currencyUnits(share))
+				}
+			}
+			fmt.Println()
+		}
+		if timelockStr != "" {
+			js, _ := json.MarshalIndent(timelockUC, "", "  ")
+			fmt.Fprintln(infoOut(), "The recipient will need the following unlock conditions to spend the timelocked output:")
+			fmt.Fprintln(infoOut(), string(js))
+			fmt.Fprintln(infoOut())
+		}
+
+		if dumpUnsigned != "" {
+			writeTxn(dumpUnsigned, txn)
+			fmt.Fprintln(infoOut(), "Wrote unsigned transaction to", dumpUnsigned)
+		}
+		if broadcast && confirmTotal && !yes {
+			// confirm before signing, so that a Ledger is not asked to
+			// approve a transaction that will be discarded
+			confirmRecipientTotal(recipSum)
+		}
+		if sign {
+			if root.ledger {
+				err := signFlowCold(c, &txn, nil)
+				checkSigning(err, "Could not sign transaction")
+			} else {
+				err := signFlowHot(c, &txn, nil)
+				checkSigning(err, "Could not sign transaction")
+			}
+		} else {
+			fmt.Fprintln(infoOut(), "Transaction has not been signed. You can sign it with the 'sign' command.")
+		}
+
+		if broadcast {
+			err := broadcastFlow(bc, txn)
+			checkBroadcast(c, err, untrackOnFailure)
+			return
+		}
+
+		if qrBundle != "" {
+			n := writeQRBundle(qrBundle, []types.Transaction{txn})
+			fmt.Fprintf(infoOut(), "Wrote %v QR frame%v to %v\n", n, plural(n), qrBundle)
+		}
+		if appendTxns {
+			n := appendTxn(args[1], txn)
+			if sign {
+				fmt.Printf("Appended signed transaction to %v (%v in set)\n", args[1], n)
+			} else {
+				fmt.Printf("Appended unsigned transaction to %v (%v in set)\n", args[1], n)
+			}
+			return
+		}
+		writeTxn(args[1], txn)
+		if sign {
+			fmt.Println("Wrote signed transaction to", args[1])
+		} else {
+			fmt.Println("Wrote unsigned transaction to", args[1])
+		}
+
+	case splitCmd:
+		if estimate {
+			if len(args) != 1 {
+				cmd.Usage()
+				return
+			}
+			per := parseCurrency(args[0])
+			utxos, err := spendableOutputs(c, spendUnconfirmed)
+			check(err, "Could not get utxos")
+			checkNonEmpty(utxos)
+			feePerByte, err := c.RecommendedFee()
+			check(err, "Could not get recommended transaction fee")
+			if maxInputs > 0 {
+				utxos = largestOutputs(utxos, maxInputs)
+			}
+			n, fee, change := maxSplitOutputs(utxos, per, feePerByte)
+			if n == 0 {
+				fmt.Printf("Insufficient funds to create any outputs worth %v.\n", currencyUnits(per))
+				return
+			}
+			fmt.Println("Split estimate:")
+			fmt.Printf("- Up to %v output%v, each worth %v, totalling %v\n", n, plural(n), currencyUnits(per), currencyUnits(per.Mul64(uint64(n))))
+			fmt.Printf("- A miner fee of %v, which is %v/byte\n", currencyUnits(fee), currencyUnits(feePerByte))
+			if !change.IsZero() {
+				fmt.Printf("- A change output, containing the remaining %v\n", currencyUnits(change))
+			}
+			return
+		}
+		if !((len(args) == 3) || (len(args) == 2 && broadcast)) {
+			cmd.Usage()
+			return
+		}
+		// parse
+		n, err := strconv.Atoi(args[0])
+		check(err, "Could not parse number of outputs")
+		per := parseCurrency(args[1])
+
+		// fetch utxos and fee
+		utxos, err := spendableOutputs(c, spendUnconfirmed)
+		check(err, "Could not get utxos")
+		checkNonEmpty(utxos)
+		feePerByte, err := c.RecommendedFee()
+		check(err, "Could not get recommended transaction fee")
+
+		capped := maxInputs > 0 && len(utxos) > maxInputs
+		if capped {
+			utxos = largestOutputs(utxos, maxInputs)
+		}
+		ins, fee, change := wallet.DistributeFunds(utxos, n, per, feePerByte)
+		if len(ins) == 0 && capped {
+			check(fundsError{fmt.Sprintf("insufficient funds using at most %v inputs; consider consolidating your outputs with the 'defrag' command first", maxInputs)}, "Could not create split transaction")
+		} else if len(ins) == 0 {
+			check(fundsError{"insufficient funds"}, "Could not create split transaction")
+		}
+
+		// get change output
+		changeAddr := getChangeAddr(c, changeStrategy, changeAddrStr, smallestOutput(ins).UnlockHash, root.ledger)
+
+		// create txn
+		txn := types.Transaction{
+			SiacoinInputs:  make([]types.SiacoinInput, len(ins)),
+			SiacoinOutputs: make([]types.SiacoinOutput, n, n+1),
+			MinerFees:      []types.Currency{fee},
+		}
+		for i, o := range ins {
+			info, err := c.AddressInfo(o.UnlockHash)
+			check(err, "Could not get address info")
+			txn.SiacoinInputs[i] = types.SiacoinInput{
+				ParentID:         o.ID,
+				UnlockConditions: info.UnlockConditions,
+			}
+		}
+		for i := range txn.SiacoinOutputs {
+			txn.SiacoinOutputs[i] = types.SiacoinOutput{
+				UnlockHash: changeAddr,
+				Value:      per,
+			}
+		}
+		if !change.IsZero() {
+			txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
+				UnlockHash: changeAddr,
+				Value:      change,
+			})
+		}
+		if randomizeOutputs {
+			shuffleOutputs(txn.SiacoinOutputs)
+		} else if sortOrder != "" {
+			sortOutputs(txn.SiacoinOutputs, sortOrder == "desc")
+		}
+
+		checkFeeCap(txn, feeCap)
+		if !quiet {
+			fmt.Println("Transaction summary:")
+			fmt.Printf("- %v input%v, totalling %v\n", len(ins), plural(len(ins)), currencyUnits(wallet.SumOutputs(ins)))
+			fmt.Printf("- %v outputs, each worth %v, totalling %v\n", n, currencyUnits(per), currencyUnits(per.Mul64(uint64(n))))
+			fmt.Printf("- A miner fee of %v, which is %v/byte\n", currencyUnits(fee), currencyUnits(feePerByte))
+			if !change.IsZero() {
+				fmt.Printf("- A change output, containing the remaining %v\n", currencyUnits(change))
+			}
+			fmt.Println()
+		}
+
+		if dumpUnsigned != "" {
+			writeTxn(dumpUnsigned, txn)
+			fmt.Fprintln(infoOut(), "Wrote unsigned transaction to", dumpUnsigned)
+		}
+		if sign {
+			if root.ledger {
+				err := signFlowCold(c, &txn, nil)
+				checkSigning(err, "Could not sign transaction")
+			} else {
+				err := signFlowHot(c, &txn, nil)
+				checkSigning(err, "Could not sign transaction")
+			}
+		} else {
+			fmt.Fprintln(infoOut(), "Transaction has not been signed. You can sign it with the 'sign' command.")
+		}
+
+		if broadcast {
+			err := broadcastFlow(bc, txn)
+			checkBroadcast(c, err, untrackOnFailure)
+			return
+		}
+
+		if appendTxns {
+			n := appendTxn(args[2], txn)
+			if sign {
+				fmt.Printf("Appended signed transaction to %v (%v in set)\n", args[2], n)
+			} else {
+				fmt.Printf("Appended unsigned transaction to %v (%v in set)\n", args[2], n)
+			}
+			return
+		}
+		writeTxn(args[2], txn)
+		if sign {
+			fmt.Println("Wrote signed transaction to", args[2])
+		} else {
+			fmt.Println("Wrote unsigned transaction to", args[2])
+		}
+
+	case defragCmd:
+		if !((len(args) == 2) || (len(args) == 1 && (broadcast || estimateOnly))) {
+			cmd.Usage()
+			return
+		}
+		// parse
+		min := parseCurrency(args[0])
+		var dustAddr types.UnlockHash
+		if dustAddrStr != "" {
+			if changeAddrStr != "" || changeStrategy != "new" {
+				checkUsage(errors.New("-dust cannot be combined with -change or -change-strategy"), "Invalid flags")
+			}
+			var err error
+			dustAddr, err = parseAddress(dustAddrStr)
+			checkUsage(err, "Invalid -dust address")
+		}
+
+		// fetch utxos and fee
+		utxos, err := spendableOutputs(c, spendUnconfirmed)
+		check(err, "Could not get utxos")
+		checkNonEmpty(utxos)
+		feePerByte, err := c.RecommendedFee()
+		check(err, "Could not get recommended transaction fee")
+
+		limit := 100
+		if maxInputs > 0 {
+			limit = maxInputs
+		}
+		ins := dustOutputs(utxos, min, limit)
+		if len(ins) == 0 {
+			check(fmt.Errorf("no outputs worth less than %v", currencyUnits(min)), "Could not create defrag transaction")
+		}
+
+		// create txn; the output address does not affect the size, so it is
+		// filled in after the estimate
+		txn := types.Transaction{
+			SiacoinInputs: make([]types.SiacoinInput, len(ins)),
+			SiacoinOutputs: []types.SiacoinOutput{{
+				Value: types.SiacoinPrecision, // placeholder, for fee calculation
+			}},
+			MinerFees: []types.Currency{types.SiacoinPrecision}, // placeholder, for fee calculation
+		}
+		for i, o := range ins {
+			info, err := c.AddressInfo(o.UnlockHash)
+			check(err, "Could not get address info")
+			txn.SiacoinInputs[i] = types.SiacoinInput{
+				ParentID:         o.ID,
+				UnlockConditions: info.UnlockConditions,
+			}
+		}
+		total, fee, net, err := defragEstimate(ins, txn.MarshalSiaSize(), feePerByte)
+		check(err, "Could not create defrag transaction")
+		if estimateOnly {
+			fmt.Printf("Inputs:     %v (%v output%v)\n", currencyUnits(total), len(ins), plural(len(ins)))
+			fmt.Printf("Miner fee:  %v (%v/byte)\n", currencyUnits(fee), currencyUnits(feePerByte))
+			fmt.Printf("Net amount: %v\n", currencyUnits(net))
+			return
+		}
+
+		// get change output
+		var changeAddr types.UnlockHash
+		if dustAddrStr != "" {
+			changeAddr = dustAddr
+		} else {
+			changeAddr = getChangeAddr(c, changeStrategy, changeAddrStr, smallestOutput(ins).UnlockHash, root.ledger)
+		}
+		txn.SiacoinOutputs[0] = types.SiacoinOutput{UnlockHash: changeAddr, Value: net}
+		txn.MinerFees[0] = fee
+
+		checkFeeCap(txn, feeCap)
+		if !quiet {
+			fmt.Println("Transaction summary:")
+			fmt.Printf("- %v input%v, totalling %v\n", len(ins), plural(len(ins)), currencyUnits(total))
+			if dustAddrStr != "" {
+				fmt.Printf("- 1 output to %v, totalling %v\n", dustAddr, currencyUnits(txn.SiacoinOutputs[0].Value))
+			} else {
+				fmt.Printf("- 1 change output, totalling %v\n", currencyUnits(txn.SiacoinOutputs[0].Value))
+			}
+			fmt.Printf("- A miner fee of %v, which is %v/byte\n", currencyUnits(txn.MinerFees[0]), currencyUnits(feePerByte))
+			fmt.Println()
+		}
+		if dustAddrStr != "" {
+			fmt.Fprintf(infoOut(), "Sweeping %v dust output%v, totalling %v, to %v\n", len(ins), plural(len(ins)), currencyUnits(total), dustAddr)
+		}
+
+		if dumpUnsigned != "" {
+			writeTxn(dumpUnsigned, txn)
+			fmt.Fprintln(infoOut(), "Wrote unsigned transaction to", dumpUnsigned)
+		}
+		if sign {
+			if root.ledger {
+				err := signFlowCold(c, &txn, nil)
+				checkSigning(err, "Could not sign transaction")
+			} else {
+				err := signFlowHot(c, &txn, nil)
+				checkSigning(err, "Could not sign transaction")
+			}
+		} else {
+			fmt.Fprintln(infoOut(), "Transaction has not been signed. You can sign it with the 'sign' command.")
+		}
+
+		if broadcast {
+			err := broadcastFlow(bc, txn)
+			checkBroadcast(c, err, untrackOnFailure)
+			return
+		}
+
+		writeTxn(args[1], txn)
+		if sign {
+			fmt.Println("Wrote signed transaction to", args[1])
+		} else {
+			fmt.Println("Wrote unsigned transaction to", args[1])
+		}
+
+	case signCmd:
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		var txns []types.Transaction
+		if qrIn {
+			txns = readQRBundle(args[0])
+		} else {
+			txns = readTxnSet(args[0])
+		}
+		if parentsPath != "" {
+			state.parentOutputs = readParentOutputs(parentsPath)
+		}
+		var keyHints map[int]uint64
+		if keyIndicesStr != "" {
+			if len(txns) > 1 {
+				checkUsage(errors.New("key index hints cannot be used with transaction sets"), "Could not sign transaction")
+			}
+			keyHints = parseKeyHints(keyIndicesStr)
+		}
+		var onlyInputs []int
+		if onlyInputsStr != "" {
+			if keyIndicesStr != "" {
+				checkUsage(errors.New("-only-inputs and -key-indices are mutually exclusive"), "Could not sign transaction")
+			} else if len(txns) > 1 {
+				checkUsage(errors.New("-only-inputs cannot be used with transaction sets"), "Could not sign transaction")
+			}
+			onlyInputs = parseInputIndices(onlyInputsStr, len(txns[0].SiacoinInputs))
+			keys := walletInputKeys(c, txns[0], onlyInputs)
+			if root.ledger {
+				keyHints = keys
+			}
+		}
+		if broadcast && !quiet {
+			// the transaction will be broadcast without further review, so
+			// summarize it as the txn command does
+			var values map[types.SiacoinOutputID]types.Currency
+			var owned map[types.UnlockHash]bool
+			if _, err := c.ConsensusInfo(); err == nil {
+				values = walletOutputValues(c)
+				owned = ownedAddresses(c)
+			}
+			for _, txn := range txns {
+				printSignSummary(txn, values, owned)
+			}
+		}
+		for i := range txns {
+			if len(txns) > 1 {
+				fmt.Fprintf(infoOut(), "Signing transaction %v of %v.\n", i+1, len(txns))
+			}
+			if root.ledger {
+				err := signFlowCold(c, &txns[i], keyHints)
+				checkSigning(err, "Could not sign transaction")
+			} else if len(keyHints) > 0 {
+				err := signFlowHints(&txns[i], keyHints)
+				checkSigning(err, "Could not sign transaction")
+			} else {
+				err := signFlowHot(c, &txns[i], onlyInputs)
+				checkSigning(err, "Could not sign transaction")
+			}
+		}
+		// make sure that every input we meant to sign was actually signed
+		var owned map[types.UnlockHash]bool
+		if len(keyHints) == 0 && len(onlyInputs) == 0 {
+			owned = ownedAddresses(c)
+		}
+		var incomplete bool
+		for i, txn := range txns {
+			expected := onlyInputs
+			if len(keyHints) > 0 {
+				expected = nil
+				for inputIndex := range keyHints {
+					expected = append(expected, inputIndex)
+				}
+				sort.Ints(expected)
+			} else if owned != nil {
+				expected = walletInputIndices(txn, owned)
+			}
+			if missing := unsignedInputs(txn, expected); len(missing) > 0 {
+				fmt.Fprintf(infoOut(), "WARNING: %v of %v wallet input%v in transaction %v remain unsigned: %v\n",
+					len(missing), len(expected), plural(len(expected)), i+1, strings.Trim(fmt.Sprint(missing), "[]"))
+				incomplete = true
+			}
+		}
+		if incomplete {
+			fmt.Fprintln(infoOut(), "The transaction will be rejected unless these inputs are signed.")
+			if broadcast && !yes {
+				fmt.Fprint(infoOut(), "Press ENTER to broadcast anyway, or Ctrl-C to cancel.")
+				bufio.NewReader(os.Stdin).ReadLine()
+				fmt.Fprintln(infoOut())
+			}
+		}
+
+		if broadcast {
+			err := broadcastFlow(bc, txns...)
+			check(err, "Could not broadcast transaction")
+		} else {
+			ext := filepath.Ext(args[0])
+			signedPath := strings.TrimSuffix(args[0], ext) + "-signed" + ext
+			if qrIn {
+				signedPath = strings.TrimSuffix(args[0], ext) + "-signed.json"
+			}
+			writeTxnSet(signedPath, txns)
+			fmt.Println("Wrote signed transaction to", signedPath+".")
+			fmt.Fprintln(infoOut(), "You can now use the 'broadcast' command to broadcast this transaction.")
+		}
+
+	case broadcastCmd:
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		txns := readTxnSet(args[0])
+		if chunkSize < 0 {
+			checkUsage(errors.New("chunk size must not be negative"), "Invalid -chunk value")
+		} else if chunkSize == 0 || len(txns) <= chunkSize {
+			err := broadcastFlow(bc, txns...)
+			check(err, "Could not broadcast transaction")
+			return
+		}
+		check(checkSetOrder(txns), "Cannot broadcast transaction set in chunks")
+		numChunks := (len(txns) + chunkSize - 1) / chunkSize
+		for i := 0; i < numChunks; i++ {
+			start, end := i*chunkSize, (i+1)*chunkSize
+			if end > len(txns) {
+				end = len(txns)
+			}
+			fmt.Fprintf(infoOut(), "Broadcasting chunk %v of %v (transactions %v-%v)...\n", i+1, numChunks, start+1, end)
+			err := broadcastFlow(bc, txns[start:end]...)
+			if err != nil && i > 0 {
+				check(err, fmt.Sprintf("Could not broadcast chunk %v of %v (chunks 1-%v were broadcast successfully)", i+1, numChunks, i))
+			}
+			check(err, fmt.Sprintf("Could not broadcast chunk %v of %v", i+1, numChunks))
+		}
+
+	case decodeCmd:
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		txns := readTxnSet(args[0])
+		var values map[types.SiacoinOutputID]types.Currency
+		var depths map[types.SiacoinOutputID]types.BlockHeight
+		if info, err := c.ConsensusInfo(); err == nil {
+			values = walletOutputValues(c)
+			_, history := fetchTransactions(c)
+			depths = outputConfirmations(history, info.Height)
+		}
+		for i, txn := range txns {
+			if i > 0 {
+				fmt.Println()
+			}
+			printDecodedTxn(txn, values, depths)
+		}
+
+	case transactionsCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+
+		if txidStr != "" {
+			var txid types.TransactionID
+			err := txid.LoadString(txidStr)
+			checkUsage(err, "Invalid transaction ID")
+			txn, err := c.Transaction(txid)
+			check(err, "Could not get transaction (it may not be relevant to this wallet)")
+			if jsonOutput {
+				js := encodeJSON(transactionEntry{txid, txn})
+				fmt.Println(string(js))
+				return
+			}
+			info, err := c.ConsensusInfo()
+			check(err, "Could not get consensus info")
+			printTransactionDetails(txid, txn, info.Height, ownedAddresses(c))
+			return
+		}
+
+		txids, txns := fetchTransactions(c)
+		// printMarker prints the marker for the next poll; it is called only
+		// once the output has been written successfully
+		printMarker := func() {}
+		if sinceStr != "" {
+			since := parseSinceMarker(c, sinceStr)
+			txids, txns = transactionsSince(txids, txns, since)
+			next := since
+			for _, txn := range txns {
+				if txn.BlockHeight > next {
+					next = txn.BlockHeight
+				}
+			}
+			printMarker = func() { fmt.Fprintln(os.Stderr, "Next -since marker:", next) }
+		}
+		if exportPath != "" {
+			entries := make([]transactionEntry, len(txids))
+			for i := range txids {
+				entries[i] = transactionEntry{txids[i], txns[i]}
+			}
+			err := ioutil.WriteFile(exportPath, encodeJSON(entries), 0666)
+			check(err, "Could not write transactions")
+			fmt.Printf("Wrote %v transaction%v to %v\n", len(entries), plural(len(entries)), exportPath)
+			printMarker()
+			return
+		}
+		if jsonOutput {
+			entries := make([]transactionEntry, len(txids))
+			for i := range txids {
+				entries[i] = transactionEntry{txids[i], txns[i]}
+			}
+			js := encodeJSON(entries)
+			fmt.Println(string(js))
+			printMarker()
+			return
+		}
+		if !showAll {
+			var hidden int
+			txids, txns, hidden = omitInternal(txids, txns)
+			if hidden > 0 {
+				defer fmt.Printf("(%v internal transaction%v hidden; use -all to display them)\n", hidden, plural(hidden))
+			}
+		}
+		if len(txids) == 0 {
+			fmt.Println("No transactions to display.")
+			printMarker()
+			return
+		}
+		var tip types.BlockHeight
+		if showTime {
+			info, err := c.ConsensusInfo()
+			check(err, "Could not get consensus info")
+			tip = info.Height
+		}
+		labels := loadLabels()
+		if txnFormat != "" && txnFormat != "default" {
+			tmpl := parseTxnFormat(txnFormat)
+			for i, txn := range txns {
+				row := txnRow{
+					TxID:    txids[i],
+					Height:  txn.BlockHeight,
+					Inflow:  currencyUnits(txn.Credit),
+					Outflow: currencyUnits(txn.Debit),
+					Delta:   txnDelta(txn),
+					Label:   labels[txids[i].String()],
+				}
+				if showTime {
+					row.Age = estimateAge(txn.BlockHeight, tip)
+				}
+				err := tmpl.Execute(os.Stdout, row)
+				check(err, "Could not format transaction")
+				fmt.Println()
+			}
+			if summary {
+				printTxnSummary(txns)
+			}
+			printMarker()
+			return
+		}
+		header := "Transaction ID                                                      Height    "
+		if showTime {
+			header += "Time (est.)       "
+		}
+		fmt.Println(header + "Gain/Loss")
+		var owned map[types.UnlockHash]bool
+		var values map[types.SiacoinOutputID]types.Currency
+		if showInputs {
+			owned = ownedAddresses(c)
+			values = outputValues(txns)
+		}
+		for i, txn := range txns {
+			line := fmt.Sprintf("%v  %8v    ", txids[i], txn.BlockHeight)
+			if showTime {
+				line += fmt.Sprintf("%-18v", estimateAge(txn.BlockHeight, tip))
+			}
+			line += txnDelta(txn)
+			if label, ok := labels[txids[i].String()]; ok {
+				line += "    " + label
+			}
+			if names := txnAliases(txn.Transaction); len(names) > 0 {
+				line += "    (" + strings.Join(names, ", ") + ")"
+			}
+			fmt.Println(line)
+			if showInputs {
+				printWalletInputs(txn.Transaction, owned, values)
+			}
+		}
+		if summary {
+			printTxnSummary(txns)
+		}
+		printMarker()
+
+	case mempoolCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		txids, txns := fetchTransactions(c)
+		var pending []int
+		for i, txn := range txns {
+			if txn.BlockHeight == 0 {
+				pending = append(pending, i)
+			}
+		}
+		if len(pending) == 0 {
+			fmt.Println("No unconfirmed transactions.")
+			return
+		}
+		fmt.Println("Transaction ID                                                      Gain/Loss")
+		for _, i := range pending {
+			fmt.Printf("%v    %v\n", txids[i], txnDelta(txns[i]))
+		}
+
+	case labelCmd:
+		cmd.Usage()
+
+	case labelSetCmd:
+		if len(args) < 2 {
+			cmd.Usage()
+			return
+		}
+		var txid types.TransactionID
+		err := txid.LoadString(args[0])
+		checkUsage(err, "Invalid transaction ID")
+		labels := loadLabels()
+		labels[txid.String()] = strings.Join(args[1:], " ")
+		saveLabels(labels)
+		fmt.Println("Label saved.")
+
+	case labelListCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		labels := loadLabels()
+		if len(labels) == 0 {
+			fmt.Println("No labels.")
+			return
+		}
+		txids := make([]string, 0, len(labels))
+		for txid := range labels {
+			txids = append(txids, txid)
+		}
+		sort.Strings(txids)
+		for _, txid := range txids {
+			fmt.Printf("%v  %v\n", txid, labels[txid])
+		}
+
+	case aliasCmd:
+		cmd.Usage()
+
+	case aliasSetCmd:
+		if len(args) != 2 {
+			cmd.Usage()
+			return
+		}
+		addr, err := parseAddress(args[1])
+		checkUsage(err, "Invalid address")
+		aliases := loadAliases()
+		aliases[args[0]] = addr
+		saveAliases(aliases)
+		fmt.Println("Alias saved.")
+
+	case aliasListCmd:
+		if len(args) != 0 {
+			cmd.Usage()
+			return
+		}
+		aliases := loadAliases()
+		if len(aliases) == 0 {
+			fmt.Println("No aliases.")
+			return
+		}
+		for _, name := range sortedAliasNames(aliases) {
+			fmt.Printf("%-20v  %v\n", name, aliases[name])
+		}
+
+	case aliasImportCmd:
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		f, err := os.Open(args[0])
+		check(err, "Could not open alias file")
+		entries, err := readAliasCSV(f)
+		f.Close()
+		check(err, "Could not read alias file")
+		aliases := loadAliases()
+		added, replaced, conflicts := mergeAliases(aliases, entries, overwrite)
+		if len(conflicts) > 0 && !overwrite {
+			for _, name := range conflicts {
+				fmt.Fprintf(os.Stderr, "%v is already assigned to %v\n", name, aliases[name])
+			}
+			check(fmt.Errorf("%v existing alias%v would be replaced; use -overwrite to replace them", len(conflicts), pluralES(len(conflicts))), "Could not import aliases")
+		}
+		saveAliases(aliases)
+		fmt.Printf("Imported %v new alias%v", added, pluralES(added))
+		if replaced > 0 {
+			fmt.Printf(" and replaced %v existing alias%v", replaced, pluralES(replaced))
+		}
+		fmt.Println(".")
+
+	case aliasExportCmd:
+		if len(args) != 1 {
+			cmd.Usage()
+			return
+		}
+		aliases := loadAliases()
+		var buf bytes.Buffer
+		err := writeAliasCSV(&buf, aliases)
+		check(err, "Could not encode aliases")
+		err = ioutil.WriteFile(args[0], buf.Bytes(), 0666)
+		check(err, "Could not write alias file")
+		fmt.Printf("Wrote %v alias%v to %v.\n", len(aliases), pluralES(len(aliases)), args[0])
+	}
+}
+
+// labelsPath returns the path of the file storing transaction labels.
+func labelsPath() string {
+	dir, err := os.UserConfigDir()
+	check(err, "Could not locate config directory")
+	return filepath.Join(dir, "walrus-cli", "labels.json")
+}
+
+// loadLabels returns the stored transaction labels, keyed by transaction ID.
+func loadLabels() map[string]string {
+	labels := make(map[string]string)
+	js, err := ioutil.ReadFile(labelsPath())
+	if os.IsNotExist(err) {
+		return labels
+	}
+	check(err, "Could not read labels file")
+	err = json.Unmarshal(js, &labels)
+	check(err, "Could not parse labels file")
+	return labels
+}
+
+func saveLabels(labels map[string]string) {
+	path := labelsPath()
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	check(err, "Could not create config directory")
+	js, _ := json.MarshalIndent(labels, "", "  ")
+	js = append(js, '\n')
+	err = ioutil.WriteFile(path, js, 0600)
+	check(err, "Could not write labels file")
+}
+
+// aliasesPath returns the path of the file storing address aliases.
+func aliasesPath() string {
+	dir, err := os.UserConfigDir()
+	check(err, "Could not locate config directory")
+	return filepath.Join(dir, "walrus-cli", "aliases.json")
+}
+
+// loadAliases returns the stored address aliases, keyed by name.
+func loadAliases() map[string]types.UnlockHash {
+	aliases := make(map[string]types.UnlockHash)
+	js, err := ioutil.ReadFile(aliasesPath())
+	if os.IsNotExist(err) {
+		return aliases
+	}
+	check(err, "Could not read aliases file")
+	err = json.Unmarshal(js, &aliases)
+	check(err, "Could not parse aliases file")
+	return aliases
+}
+
+func saveAliases(aliases map[string]types.UnlockHash) {
+	path := aliasesPath()
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	check(err, "Could not create config directory")
+	js, _ := json.MarshalIndent(aliases, "", "  ")
+	js = append(js, '\n')
+	err = ioutil.WriteFile(path, js, 0600)
+	check(err, "Could not write aliases file")
+}
+
+func sortedAliasNames(aliases map[string]types.UnlockHash) []string {
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// aliasNames inverts aliases. If an address has several aliases, the first in
+// alphabetical order is used.
+func aliasNames(aliases map[string]types.UnlockHash) map[types.UnlockHash]string {
+	names := make(map[types.UnlockHash]string, len(aliases))
+	for _, name := range sortedAliasNames(aliases) {
+		if _, ok := names[aliases[name]]; !ok {
+			names[aliases[name]] = name
+		}
+	}
+	return names
+}
 
-	cmd := flagg.Parse(flagg.Tree{
-		Cmd: rootCmd,
-		Sub: []flagg.Tree{
-			{Cmd: versionCmd},
-			{Cmd: seedCmd},
-			{Cmd: consensusCmd},
-			{Cmd: balanceCmd},
-			{Cmd: addressesCmd},
-			{Cmd: addrCmd},
-			{Cmd: txnCmd},
-			{Cmd: splitCmd},
-			{Cmd: defragCmd},
-			{Cmd: signCmd},
-			{Cmd: broadcastCmd},
-			{Cmd: transactionsCmd},
-		},
-	})
-	args := cmd.Args()
+// displayAddr returns the alias of addr, if it has one, followed by the raw
+// address when verbose is set. Otherwise it returns the raw address.
+func displayAddr(addr types.UnlockHash) string {
+	name, ok := state.addrNames[addr]
+	if !ok {
+		return addr.String()
+	} else if verbose {
+		return fmt.Sprintf("%v (%v)", name, addr)
+	}
+	return name
+}
 
-	c := walrus.NewClient(*apiAddr)
+// txnAliases returns the sorted, distinct aliases of the addresses that txn
+// spends from or sends to.
+func txnAliases(txn types.Transaction) []string {
+	seen := make(map[string]bool)
+	var names []string
+	see := func(addr types.UnlockHash) {
+		if name, ok := state.addrNames[addr]; ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, in := range txn.SiacoinInputs {
+		see(in.UnlockConditions.UnlockHash())
+	}
+	for _, o := range txn.SiacoinOutputs {
+		see(o.UnlockHash)
+	}
+	sort.Strings(names)
+	return names
+}
 
-	switch cmd {
-	case rootCmd:
-		if len(args) != 0 {
-			cmd.Usage()
-			return
+// An aliasEntry names an address.
+type aliasEntry struct {
+	Name    string
+	Address types.UnlockHash
+}
+
+// readAliasCSV reads name,address rows from r, skipping an initial header row
+// if present. It returns an error if any row is malformed, or if a name is
+// given two different addresses.
+func readAliasCSV(r io.Reader) ([]aliasEntry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && len(records[0]) == 2 &&
+		strings.EqualFold(strings.TrimSpace(records[0][0]), "name") && strings.EqualFold(strings.TrimSpace(records[0][1]), "address") {
+		records = records[1:]
+	}
+	var entries []aliasEntry
+	seen := make(map[string]types.UnlockHash)
+	for i, rec := range records {
+		if len(rec) != 2 {
+			return nil, fmt.Errorf("row %v: expected name,address, got %v field%v", i+1, len(rec), plural(len(rec)))
 		}
-		fallthrough
-	case versionCmd:
-		log.Printf("walrus-cli v0.1.0\nCommit:     %s\nRelease:    %s\nGo version: %s %s/%s\nBuild Date: %s\n",
-			githash, build.Release, runtime.Version(), runtime.GOOS, runtime.GOARCH, builddate)
+		name := strings.TrimSpace(rec[0])
+		if name == "" {
+			return nil, fmt.Errorf("row %v: name is empty", i+1)
+		}
+		addr, err := parseAddress(strings.TrimSpace(rec[1]))
+		if err != nil {
+			return nil, fmt.Errorf("row %v: %v", i+1, err)
+		}
+		if prev, ok := seen[name]; ok {
+			if prev != addr {
+				return nil, fmt.Errorf("row %v: %v is assigned two different addresses", i+1, name)
+			}
+			continue
+		}
+		seen[name] = addr
+		entries = append(entries, aliasEntry{name, addr})
+	}
+	return entries, nil
+}
 
-	case seedCmd:
-		if len(args) != 0 {
-			cmd.Usage()
-			return
+// mergeAliases adds entries to aliases. It returns the number of aliases
+// added and replaced, and the names that were already assigned to a different
+// address. Such names are only replaced if overwrite is set; otherwise, if
+// there are any conflicts, aliases is left unmodified.
+func mergeAliases(aliases map[string]types.UnlockHash, entries []aliasEntry, overwrite bool) (added, replaced int, conflicts []string) {
+	for _, e := range entries {
+		if addr, ok := aliases[e.Name]; ok && addr != e.Address {
+			conflicts = append(conflicts, e.Name)
 		}
-		fmt.Println(wallet.NewSeed())
+	}
+	if len(conflicts) > 0 && !overwrite {
+		return 0, 0, conflicts
+	}
+	for _, e := range entries {
+		if addr, ok := aliases[e.Name]; !ok {
+			added++
+		} else if addr != e.Address {
+			replaced++
+		}
+		aliases[e.Name] = e.Address
+	}
+	return added, replaced, conflicts
+}
 
-	case consensusCmd:
-		if len(args) != 0 {
-			cmd.Usage()
-			return
+// writeAliasCSV writes aliases to w as name,address rows, sorted by name and
+// preceded by a header row.
+func writeAliasCSV(w io.Writer, aliases map[string]types.UnlockHash) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "address"})
+	for _, name := range sortedAliasNames(aliases) {
+		cw.Write([]string{name, aliases[name].String()})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// A walletOverview summarizes the state of the wallet. Balance reflects only
+// confirmed transactions, while Pending also reflects transactions in limbo,
+// i.e. those that have been broadcast but not yet confirmed.
+type walletOverview struct {
+	Balance   types.Currency    `json:"balance"`
+	Pending   types.Currency    `json:"pendingBalance"`
+	UTXOs     int               `json:"utxos"`
+	Addresses int               `json:"addresses"`
+	Height    types.BlockHeight `json:"height"`
+}
+
+// An overviewClient provides the wallet data summarized by getOverview. It is
+// satisfied by *walrus.Client.
+type overviewClient interface {
+	Balance(limbo bool) (types.Currency, error)
+	UnspentOutputs(limbo bool) ([]wallet.UnspentOutput, error)
+	Addresses() ([]types.UnlockHash, error)
+	ConsensusInfo() (walrus.ResponseConsensus, error)
+}
+
+func getOverview(c overviewClient) (walletOverview, error) {
+	confirmed, err := c.Balance(false)
+	if err != nil {
+		return walletOverview{}, fmt.Errorf("could not get balance: %w", err)
+	}
+	pending, err := c.Balance(true)
+	if err != nil {
+		return walletOverview{}, fmt.Errorf("could not get balance: %w", err)
+	}
+	utxos, err := c.UnspentOutputs(true)
+	if err != nil {
+		return walletOverview{}, fmt.Errorf("could not get utxos: %w", err)
+	}
+	addrs, err := c.Addresses()
+	if err != nil {
+		return walletOverview{}, fmt.Errorf("could not get address list: %w", err)
+	}
+	info, err := c.ConsensusInfo()
+	if err != nil {
+		return walletOverview{}, fmt.Errorf("could not get consensus info: %w", err)
+	}
+	return walletOverview{
+		Balance:   confirmed,
+		Pending:   pending,
+		UTXOs:     len(utxos),
+		Addresses: len(addrs),
+		Height:    info.Height,
+	}, nil
+}
+
+// A txnRow contains the fields available to -format templates.
+type txnRow struct {
+	TxID    types.TransactionID
+	Height  types.BlockHeight
+	Inflow  string
+	Outflow string
+	Delta   string
+	Label   string
+	Age     string
+}
+
+// txnFormatPresets are the named -format templates.
+var txnFormatPresets = map[string]string{
+	"compact": "{{.TxID}} {{.Height}} {{.Delta}}",
+}
+
+// parseTxnFormat parses a -format template or preset, aborting if it is
+// invalid or references unknown fields.
+func parseTxnFormat(format string) *template.Template {
+	if preset, ok := txnFormatPresets[format]; ok {
+		format = preset
+	}
+	tmpl, err := template.New("format").Parse(format)
+	checkUsage(err, "Invalid format")
+	// execute against an empty row to detect unknown fields
+	err = tmpl.Execute(ioutil.Discard, txnRow{})
+	checkUsage(err, "Invalid format")
+	return tmpl
+}
+
+// fetchTransactions returns the IDs and details of all transactions relevant
+// to the wallet.
+func fetchTransactions(c *walrus.Client) ([]types.TransactionID, []walrus.ResponseTransactionsID) {
+	txids, err := c.Transactions(-1)
+	check(err, "Could not get transactions")
+	txns := make([]walrus.ResponseTransactionsID, len(txids))
+	p := newProgress("Fetching transactions", len(txids))
+	for i, txid := range txids {
+		txns[i], err = c.Transaction(txid)
+		check(err, "Could not get transaction")
+		p.Inc()
+	}
+	p.Done()
+	return txids, txns
+}
+
+// A transactionEntry pairs a transaction with its ID, for JSON output.
+type transactionEntry struct {
+	ID types.TransactionID `json:"id"`
+	walrus.ResponseTransactionsID
+}
+
+// parseSinceMarker parses s as either a block height or the ID of a wallet
+// transaction, returning the corresponding height.
+func parseSinceMarker(c *walrus.Client, s string) types.BlockHeight {
+	if height, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return types.BlockHeight(height)
+	}
+	var txid types.TransactionID
+	err := txid.LoadString(s)
+	checkUsage(err, "Invalid -since marker (must be a block height or transaction ID)")
+	txn, err := c.Transaction(txid)
+	check(err, "Could not get -since transaction")
+	if txn.BlockHeight == 0 {
+		checkUsage(errors.New("transaction is not yet confirmed"), "Invalid -since marker")
+	}
+	return txn.BlockHeight
+}
+
+// transactionsSince returns the transactions confirmed after height, along
+// with any unconfirmed transactions. Unconfirmed transactions are included on
+// every poll until they are confirmed.
+func transactionsSince(txids []types.TransactionID, txns []walrus.ResponseTransactionsID, height types.BlockHeight) ([]types.TransactionID, []walrus.ResponseTransactionsID) {
+	var newIDs []types.TransactionID
+	var newTxns []walrus.ResponseTransactionsID
+	for i, txn := range txns {
+		if txn.BlockHeight == 0 || txn.BlockHeight > height {
+			newIDs = append(newIDs, txids[i])
+			newTxns = append(newTxns, txn)
 		}
-		info, err := c.ConsensusInfo()
-		check(err, "Could not get consensus info")
-		fmt.Printf("Height:    %v\nChange ID: %v\n", info.Height, info.CCID)
+	}
+	return newIDs, newTxns
+}
 
-	case balanceCmd:
-		if len(args) != 0 {
-			cmd.Usage()
-			return
+// ownedAddresses returns the set of addresses tracked by the wallet.
+func ownedAddresses(c *walrus.Client) map[types.UnlockHash]bool {
+	addrs, err := c.Addresses()
+	check(err, "Could not get address list")
+	owned := make(map[types.UnlockHash]bool, len(addrs))
+	for _, addr := range addrs {
+		owned[addr] = true
+	}
+	return owned
+}
+
+// outputValues returns the value of every siacoin output created by txns.
+func outputValues(txns []walrus.ResponseTransactionsID) map[types.SiacoinOutputID]types.Currency {
+	values := make(map[types.SiacoinOutputID]types.Currency)
+	for _, txn := range txns {
+		for i, o := range txn.Transaction.SiacoinOutputs {
+			values[txn.Transaction.SiacoinOutputID(uint64(i))] = o.Value
 		}
-		bal, err := c.Balance(true)
-		check(err, "Could not get balance")
-		fmt.Println(currencyUnits(bal))
+	}
+	return values
+}
 
-	case addressesCmd:
-		if len(args) != 0 {
-			cmd.Usage()
-			return
+// printWalletInputs lists the inputs of txn that spend wallet outputs, along
+// with their values, if known.
+func printWalletInputs(txn types.Transaction, owned map[types.UnlockHash]bool, values map[types.SiacoinOutputID]types.Currency) {
+	for _, sci := range txn.SiacoinInputs {
+		if !owned[sci.UnlockConditions.UnlockHash()] {
+			continue
 		}
-		addrs, err := c.Addresses()
-		check(err, "Could not get address list")
-		if len(addrs) == 0 {
-			fmt.Println("No addresses.")
+		value := "unknown value"
+		if v, ok := values[sci.ParentID]; ok {
+			value = currencyUnits(v)
+		}
+		fmt.Printf("    spent %v  %v\n", sci.ParentID, value)
+	}
+}
+
+func printTransactionDetails(txid types.TransactionID, txn walrus.ResponseTransactionsID, tip types.BlockHeight, owned map[types.UnlockHash]bool) {
+	ownedStr := func(addr types.UnlockHash) string {
+		if owned[addr] {
+			return " (wallet)"
+		}
+		return ""
+	}
+	fmt.Println("Transaction ID:", txid)
+	if txn.BlockHeight == 0 {
+		fmt.Println("Status:         Unconfirmed")
+	} else {
+		confs := tip - txn.BlockHeight + 1
+		fmt.Printf("Status:         Confirmed in block %v (%v confirmation%v)\n", txn.BlockHeight, confs, plural(int(confs)))
+	}
+	fmt.Println("Net effect:    ", txnDelta(txn))
+	fmt.Println()
+	fmt.Printf("%v input%v:\n", len(txn.Transaction.SiacoinInputs), plural(len(txn.Transaction.SiacoinInputs)))
+	for _, in := range txn.Transaction.SiacoinInputs {
+		addr := in.UnlockConditions.UnlockHash()
+		fmt.Printf("    %v\n        spending %v%v\n", in.ParentID, displayAddr(addr), ownedStr(addr))
+	}
+	fmt.Printf("%v output%v:\n", len(txn.Transaction.SiacoinOutputs), plural(len(txn.Transaction.SiacoinOutputs)))
+	for _, o := range txn.Transaction.SiacoinOutputs {
+		fmt.Printf("    %v%v receiving %v\n", displayAddr(o.UnlockHash), ownedStr(o.UnlockHash), currencyUnits(o.Value))
+	}
+	printSiafundClaims(os.Stdout, txn.Transaction)
+	for _, fee := range txn.Transaction.MinerFees {
+		fmt.Println("    A miner fee of", currencyUnits(fee))
+	}
+}
+
+// printSiafundClaims describes the Siafund outputs of txn, along with the
+// Siacoin claim outputs generated by its Siafund inputs. The value of a claim
+// depends on the state of the Siafund pool when the transaction is confirmed,
+// so it cannot be computed in advance.
+func printSiafundClaims(w io.Writer, txn types.Transaction) {
+	for _, sfo := range txn.SiafundOutputs {
+		fmt.Fprintln(w, "   ", displayAddr(sfo.UnlockHash), "receiving", sfo.Value, "SF")
+	}
+	for _, sfi := range txn.SiafundInputs {
+		fmt.Fprintln(w, "    A Siacoin claim for Siafund output", sfi.ParentID, "sent to", displayAddr(sfi.ClaimUnlockHash))
+	}
+	if len(txn.SiafundInputs) > 0 {
+		fmt.Fprintln(w, "    (The value of each claim depends on the Siafund pool at the time the transaction is confirmed.)")
+	}
+}
+
+// printSignSummary displays a summary of txn in the style of the txn command.
+// If values and owned are nil, input values are reported as unknown and change
+// outputs cannot be distinguished from recipients.
+func printSignSummary(txn types.Transaction, values map[types.SiacoinOutputID]types.Currency, owned map[types.UnlockHash]bool) {
+	var inputSum types.Currency
+	resolved := values != nil
+	for _, in := range txn.SiacoinInputs {
+		if v, ok := values[in.ParentID]; ok {
+			inputSum = inputSum.Add(v)
 		} else {
-			for _, addr := range addrs {
-				fmt.Println(addr)
+			resolved = false
+		}
+	}
+	var recipSum, change types.Currency
+	var numRecipients int
+	for _, o := range txn.SiacoinOutputs {
+		if owned[o.UnlockHash] {
+			change = change.Add(o.Value)
+		} else {
+			recipSum = recipSum.Add(o.Value)
+			numRecipients++
+		}
+	}
+	var fees types.Currency
+	for _, fee := range txn.MinerFees {
+		fees = fees.Add(fee)
+	}
+	fmt.Println("Transaction summary:")
+	if resolved {
+		fmt.Printf("- %v input%v, totalling %v\n", len(txn.SiacoinInputs), plural(len(txn.SiacoinInputs)), currencyUnits(inputSum))
+	} else {
+		fmt.Printf("- %v input%v, of unknown total value\n", len(txn.SiacoinInputs), plural(len(txn.SiacoinInputs)))
+	}
+	fmt.Printf("- %v recipient%v, totalling %v\n", numRecipients, plural(numRecipients), currencyUnits(recipSum))
+	fmt.Printf("- A miner fee of %v\n", currencyUnits(fees))
+	if !change.IsZero() {
+		fmt.Printf("- A change output, sending %v back to your wallet\n", currencyUnits(change))
+	}
+	fmt.Printf("- A total cost of %v\n", currencyUnits(recipSum.Add(fees)))
+	fmt.Println()
+}
+
+// walletOutputValues returns the values of all outputs created by the wallet's
+// transactions, along with its current unspent outputs, keyed by ID.
+func walletOutputValues(c *walrus.Client) map[types.SiacoinOutputID]types.Currency {
+	values := make(map[types.SiacoinOutputID]types.Currency)
+	utxos, err := c.UnspentOutputs(false)
+	check(err, "Could not get utxos")
+	for _, o := range utxos {
+		values[o.ID] = o.Value
+	}
+	_, txns := fetchTransactions(c)
+	for _, txn := range txns {
+		for i, o := range txn.Transaction.SiacoinOutputs {
+			values[txn.Transaction.SiacoinOutputID(uint64(i))] = o.Value
+		}
+	}
+	return values
+}
+
+// outputConfirmations returns the number of confirmations of each output
+// created by txns, given the current height. Outputs created by unconfirmed
+// transactions have zero confirmations.
+func outputConfirmations(txns []walrus.ResponseTransactionsID, tip types.BlockHeight) map[types.SiacoinOutputID]types.BlockHeight {
+	depths := make(map[types.SiacoinOutputID]types.BlockHeight)
+	for _, txn := range txns {
+		var depth types.BlockHeight
+		if txn.BlockHeight != 0 && txn.BlockHeight <= tip {
+			depth = tip - txn.BlockHeight + 1
+		}
+		for i := range txn.Transaction.SiacoinOutputs {
+			depths[txn.Transaction.SiacoinOutputID(uint64(i))] = depth
+		}
+	}
+	return depths
+}
+
+// printDecodedTxn displays the contents of txn. If values is non-nil, it is
+// used to resolve the value of each input and verify the miner fee. If depths
+// is non-nil, it is used to display the confirmations of each input.
+func printDecodedTxn(txn types.Transaction, values map[types.SiacoinOutputID]types.Currency, depths map[types.SiacoinOutputID]types.BlockHeight) {
+	fmt.Println("Transaction ID:", txn.ID())
+	var inputSum types.Currency
+	resolved := values != nil
+	fmt.Printf("%v input%v:\n", len(txn.SiacoinInputs), plural(len(txn.SiacoinInputs)))
+	for _, in := range txn.SiacoinInputs {
+		fmt.Printf("    %v\n        spending %v", in.ParentID, displayAddr(in.UnlockConditions.UnlockHash()))
+		if v, ok := values[in.ParentID]; ok {
+			fmt.Printf(", worth %v", currencyUnits(v))
+			inputSum = inputSum.Add(v)
+		} else {
+			resolved = false
+		}
+		if depth, ok := depths[in.ParentID]; ok && depth == 0 {
+			fmt.Print(" (unconfirmed)")
+		} else if ok {
+			fmt.Printf(" (%v confirmation%v)", depth, plural(int(depth)))
+		}
+		fmt.Println()
+	}
+	var outputSum types.Currency
+	fmt.Printf("%v output%v:\n", len(txn.SiacoinOutputs), plural(len(txn.SiacoinOutputs)))
+	labels := decodeOutputLabels(txn)
+	for i, o := range txn.SiacoinOutputs {
+		fmt.Printf("    %v receiving %v", displayAddr(o.UnlockHash), currencyUnits(o.Value))
+		if label, ok := labels[uint64(i)]; ok {
+			fmt.Printf(" (label: %q)", label)
+		}
+		fmt.Println()
+		outputSum = outputSum.Add(o.Value)
+	}
+	printSiafundClaims(os.Stdout, txn)
+	var fees types.Currency
+	for _, fee := range txn.MinerFees {
+		fmt.Println("    A miner fee of", currencyUnits(fee))
+		fees = fees.Add(fee)
+	}
+	fmt.Printf("%v signature%v\n", len(txn.TransactionSignatures), plural(len(txn.TransactionSignatures)))
+
+	switch {
+	case values == nil:
+		fmt.Println("The walrus server is unreachable, so input values are unknown and the fee cannot be verified.")
+	case !resolved:
+		fmt.Println("Some inputs do not belong to this wallet, so the fee cannot be verified.")
+	case inputSum.Cmp(outputSum) < 0:
+		fmt.Printf("Invalid transaction: outputs (%v) exceed inputs (%v).\n", currencyUnits(outputSum), currencyUnits(inputSum))
+	case inputSum.Sub(outputSum).Cmp(fees) != 0:
+		fmt.Printf("Invalid transaction: inputs minus outputs is %v, but miner fees total %v.\n", currencyUnits(inputSum.Sub(outputSum)), currencyUnits(fees))
+	default:
+		fmt.Printf("Verified fee: inputs (%v) minus outputs (%v) is %v.\n", currencyUnits(inputSum), currencyUnits(outputSum), currencyUnits(fees))
+	}
+}
+
+// watchBalance polls the wallet's balance every interval, printing a line
+// whenever it differs from bal, until interrupted.
+func watchBalance(c *walrus.Client, bal types.Currency, interval time.Duration) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	fmt.Printf("%v  %v\n", time.Now().Format("2006-01-02 15:04:05"), currencyUnits(bal))
+	for {
+		select {
+		case <-interrupt:
+			fmt.Println()
+			fmt.Println("Stopped watching.")
+			return
+		case <-ticker.C:
+		}
+		newBal, err := c.Balance(true)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not get balance:", err)
+			continue
+		}
+		var delta string
+		switch newBal.Cmp(bal) {
+		case 0:
+			continue
+		case 1:
+			delta = "+" + currencyUnits(newBal.Sub(bal))
+		case -1:
+			delta = "-" + currencyUnits(bal.Sub(newBal))
+		}
+		fmt.Printf("%v  %v  (%v)\n", time.Now().Format("2006-01-02 15:04:05"), currencyUnits(newBal), delta)
+		bal = newBal
+	}
+}
+
+// runBatch executes each command listed in filename, using the settings and
+// clients of root.
+func runBatch(root *rootConfig, filename string, continueOnError bool) {
+	f, err := os.Open(filename)
+	check(err, "Could not open batch file")
+	defer f.Close()
+	var lines [][]string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, strings.Fields(line))
+		}
+	}
+	check(s.Err(), "Could not read batch file")
+
+	var failed int
+	for i, fields := range lines {
+		fmt.Println("==> walrus-cli", strings.Join(fields, " "))
+		if err := runBatchCommand(root, fields); err != nil {
+			log.Println(err)
+			failed++
+			if !continueOnError {
+				fatalf("Batch stopped after command %v of %v failed.", i+1, len(lines))
+			}
+		}
+		fmt.Println()
+	}
+	if failed > 0 {
+		fatalf("%v of %v commands failed.", failed, len(lines))
+	}
+}
+
+// runBatchCommand executes a single command of a batch, returning any fatal
+// error that it encounters.
+func runBatchCommand(root *rootConfig, fields []string) (err error) {
+	inBatch = true
+	defer func() {
+		inBatch = false
+		root.restore()
+		if r := recover(); r != nil {
+			be, ok := r.(batchError)
+			if !ok {
+				panic(r)
 			}
+			err = errors.New(be.msg)
+		}
+	}()
+	// flags are defined anew by each run, so they require a fresh root
+	os.Args = append(os.Args[:1:1], fields...)
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flagg.Root = flag.CommandLine
+	run(root)
+	return nil
+}
+
+// omitInternal removes the transactions that do not change the wallet's
+// balance, returning the remainder and the number removed.
+func omitInternal(txids []types.TransactionID, txns []walrus.ResponseTransactionsID) ([]types.TransactionID, []walrus.ResponseTransactionsID, int) {
+	var keptIDs []types.TransactionID
+	var kept []walrus.ResponseTransactionsID
+	for i, txn := range txns {
+		if txn.Credit.Cmp(txn.Debit) != 0 {
+			keptIDs = append(keptIDs, txids[i])
+			kept = append(kept, txn)
+		}
+	}
+	return keptIDs, kept, len(txns) - len(kept)
+}
+
+// txnDelta returns the net effect of txn on the wallet's balance.
+func txnDelta(txn walrus.ResponseTransactionsID) string {
+	if txn.Credit.Cmp(txn.Debit) == 0 {
+		return "internal"
+	} else if txn.Debit.IsZero() {
+		return "+" + currencyUnits(txn.Credit)
+	}
+	return "-" + currencyUnits(txn.Debit)
+}
+
+// printTxnSummary prints the total inflow, outflow, and net change of txns.
+func printTxnSummary(txns []walrus.ResponseTransactionsID) {
+	var inflow, outflow types.Currency
+	for _, txn := range txns {
+		inflow = inflow.Add(txn.Credit)
+		outflow = outflow.Add(txn.Debit)
+	}
+	net := "+" + currencyUnits(inflow.Sub(outflow))
+	if inflow.Cmp(outflow) < 0 {
+		net = "-" + currencyUnits(outflow.Sub(inflow))
+	}
+	fmt.Println()
+	fmt.Println("Total inflow: ", currencyUnits(inflow))
+	fmt.Println("Total outflow:", currencyUnits(outflow))
+	fmt.Println("Net change:   ", net)
+}
+
+// estimateAge estimates how long ago the block at height was mined, given the
+// current height, and formats it as a human-readable relative time. A height
+// of 0 denotes an unconfirmed transaction.
+func estimateAge(height, tip types.BlockHeight) string {
+	if height == 0 {
+		// the transaction has not been confirmed
+		return "unconfirmed"
+	} else if height >= tip {
+		return "~just now"
+	}
+	age := time.Duration(tip-height) * time.Duration(types.BlockFrequency) * time.Second
+	switch {
+	case age < time.Hour:
+		n := int(age / time.Minute)
+		return fmt.Sprintf("~%v minute%v ago", n, plural(n))
+	case age < 24*time.Hour:
+		n := int(age / time.Hour)
+		return fmt.Sprintf("~%v hour%v ago", n, plural(n))
+	default:
+		n := int(age / (24 * time.Hour))
+		return fmt.Sprintf("~%v day%v ago", n, plural(n))
+	}
+}
+
+// prunableAddresses returns the addresses in addrs that hold none of utxos
+// and last appeared in txns more than inactive blocks before tip. Addresses that
+// do not appear in txns at all are never prunable.
+func prunableAddresses(addrs []types.UnlockHash, utxos []wallet.UnspentOutput, txns []walrus.ResponseTransactionsID, tip, inactive types.BlockHeight) []types.UnlockHash {
+	funded := make(map[types.UnlockHash]bool)
+	for _, o := range utxos {
+		funded[o.UnlockHash] = true
+	}
+	lastSeen := make(map[types.UnlockHash]types.BlockHeight)
+	see := func(addr types.UnlockHash, height types.BlockHeight) {
+		if height == 0 {
+			height = tip // unconfirmed
+		}
+		if height > lastSeen[addr] {
+			lastSeen[addr] = height
+		}
+	}
+	for _, txn := range txns {
+		for _, sci := range txn.Transaction.SiacoinInputs {
+			see(sci.UnlockConditions.UnlockHash(), txn.BlockHeight)
+		}
+		for _, sco := range txn.Transaction.SiacoinOutputs {
+			see(sco.UnlockHash, txn.BlockHeight)
+		}
+	}
+	var prunable []types.UnlockHash
+	for _, addr := range addrs {
+		seen, ok := lastSeen[addr]
+		if ok && !funded[addr] && seen+inactive < tip {
+			prunable = append(prunable, addr)
+		}
+	}
+	return prunable
+}
+
+// spendableOutputs returns the wallet's unspent outputs for funding a
+// transaction. The outputs always reflect the transactions in limbo (i.e.
+// broadcast but not yet confirmed), so that outputs they spend are never
+// selected again. Outputs created by transactions in limbo are excluded unless
+// spendUnconfirmed is set, since they become invalid if their parent is never
+// confirmed.
+func spendableOutputs(c *walrus.Client, spendUnconfirmed bool) ([]wallet.UnspentOutput, error) {
+	utxos, err := c.UnspentOutputs(true)
+	if err != nil || spendUnconfirmed {
+		return utxos, err
+	}
+	confirmed, err := c.UnspentOutputs(false)
+	if err != nil {
+		return nil, err
+	}
+	isConfirmed := make(map[types.SiacoinOutputID]bool, len(confirmed))
+	for _, o := range confirmed {
+		isConfirmed[o.ID] = true
+	}
+	filtered := utxos[:0]
+	for _, o := range utxos {
+		if isConfirmed[o.ID] {
+			filtered = append(filtered, o)
+		}
+	}
+	return filtered, nil
+}
+
+// filterByAddress returns the outputs in utxos that belong to addr.
+func filterByAddress(utxos []wallet.UnspentOutput, addr types.UnlockHash) []wallet.UnspentOutput {
+	var filtered []wallet.UnspentOutput
+	for _, o := range utxos {
+		if o.UnlockHash == addr {
+			filtered = append(filtered, o)
+		}
+	}
+	return filtered
+}
+
+// readOutputIDs reads a newline-delimited list of output IDs from filename.
+func readOutputIDs(filename string) []types.SiacoinOutputID {
+	f, err := os.Open(filename)
+	check(err, "Could not open inputs file")
+	defer f.Close()
+	var ids []types.SiacoinOutputID
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
 		}
+		var id types.SiacoinOutputID
+		err := id.LoadString(line)
+		checkUsage(err, fmt.Sprintf("Invalid output ID on line %v of inputs file", len(ids)+1))
+		ids = append(ids, id)
+	}
+	check(s.Err(), "Could not read inputs file")
+	if len(ids) == 0 {
+		check(errors.New("no output IDs specified"), "Could not read inputs file")
+	}
+	return ids
+}
 
-	case addrCmd:
-		if len(args) > 1 {
-			cmd.Usage()
-			return
+// writeAddressExport writes the address and key index of each info to
+// filename, in either JSON or CSV format.
+func writeAddressExport(filename, format string, infos []wallet.SeedAddressInfo) error {
+	type exportEntry struct {
+		KeyIndex uint64           `json:"keyIndex"`
+		Address  types.UnlockHash `json:"address"`
+	}
+	entries := make([]exportEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = exportEntry{info.KeyIndex, info.UnlockConditions.UnlockHash()}
+	}
+	if format == "json" {
+		return ioutil.WriteFile(filename, encodeJSON(entries), 0666)
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"keyIndex", "address"})
+	for _, e := range entries {
+		w.Write([]string{strconv.FormatUint(e.KeyIndex, 10), e.Address.String()})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0666)
+}
+
+// readWatchFile reads a list of keyIndex,publicKey lines from filename. A key
+// index that appears more than once must always specify the same public key.
+func readWatchFile(filename string) []wallet.SeedAddressInfo {
+	f, err := os.Open(filename)
+	check(err, "Could not open watch file")
+	defer f.Close()
+	var infos []wallet.SeedAddressInfo
+	seen := make(map[uint64]types.SiaPublicKey)
+	s := bufio.NewScanner(f)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
 		}
-		var index uint64
-		var err error
-		if len(args) == 0 {
-			index, err = c.SeedIndex()
-			check(err, "Could not get next seed index")
-			fmt.Printf("No index specified; using lowest unused index (%v)\n", index)
-		} else {
-			index, err = strconv.ParseUint(args[0], 10, 32)
-			check(err, "Invalid index")
+		indexKey := strings.Split(line, ",")
+		if len(indexKey) != 2 {
+			checkUsage(errors.New("entries must be specified as keyIndex,publicKey"), fmt.Sprintf("Invalid entry on line %v of watch file", lineNum))
 		}
+		index, err := strconv.ParseUint(strings.TrimSpace(indexKey[0]), 10, 32)
+		checkUsage(err, fmt.Sprintf("Invalid key index on line %v of watch file", lineNum))
 		var pubkey types.SiaPublicKey
-		if *ledger {
-			nanos := getNanoS()
-			fmt.Printf("Please verify and accept the prompt on your device to generate address #%v.\n", index)
-			_, pubkey, err = nanos.GetAddress(uint32(index), false)
-			check(err, "Could not generate address")
-			fmt.Println("Compare the address displayed on your device to the address below:")
-			fmt.Println("    " + wallet.StandardAddress(pubkey).String())
-		} else {
-			seed := getSeed()
-			pubkey = seed.PublicKey(index)
-			fmt.Println("Derived address from seed:")
-			fmt.Println("    " + wallet.StandardAddress(pubkey).String())
-		}
-		if showPubkey {
-			fmt.Println("The pubkey for this address is:")
-			fmt.Println("    " + pubkey.String())
+		err = pubkey.LoadString(strings.TrimSpace(indexKey[1]))
+		checkUsage(err, fmt.Sprintf("Invalid public key on line %v of watch file", lineNum))
+		if pubkey.Algorithm != types.SignatureEd25519 {
+			checkUsage(fmt.Errorf("unsupported signature scheme %q (only ed25519 is supported)", pubkey.Algorithm), fmt.Sprintf("Invalid public key on line %v of watch file", lineNum))
 		}
-
-		// check for duplicate
-		addrInfo, err := c.AddressInfo(wallet.StandardAddress(pubkey))
-		if err == nil && addrInfo.KeyIndex == index {
-			fmt.Println(`The server reported that it is already tracking this address. No further
-action is needed. Please be aware that reusing addresses can compromise
-your privacy.`)
-			return
+		if prev, ok := seen[index]; ok {
+			if prev.String() != pubkey.String() {
+				check(fmt.Errorf("key index %v is listed with two different public keys", index), "Could not read watch file")
+			}
+			continue
 		}
-
-		fmt.Print("Press ENTER to add this address to your wallet, or Ctrl-C to cancel.")
-		bufio.NewReader(os.Stdin).ReadLine()
-		err = c.AddAddress(wallet.SeedAddressInfo{
+		seen[index] = pubkey
+		infos = append(infos, wallet.SeedAddressInfo{
 			UnlockConditions: wallet.StandardUnlockConditions(pubkey),
 			KeyIndex:         index,
 		})
-		check(err, "Could not add address to wallet")
-		fmt.Println("Address added successfully.")
+	}
+	check(s.Err(), "Could not read watch file")
+	if len(infos) == 0 {
+		check(errors.New("no public keys specified"), "Could not read watch file")
+	}
+	return infos
+}
 
-	case txnCmd:
-		if !((len(args) == 2) || (len(args) == 1 && broadcast)) {
-			cmd.Usage()
-			return
-		}
-		// parse outputs
-		pairs := strings.Split(args[0], ",")
-		outputs := make([]types.SiacoinOutput, len(pairs))
-		var recipSum types.Currency
-		for i, p := range pairs {
-			addrAmount := strings.Split(p, ":")
-			if len(addrAmount) != 2 {
-				check(errors.New("outputs must be specified in addr:amount pairs"), "Could not parse outputs")
-			}
-			err := outputs[i].UnlockHash.LoadString(strings.TrimSpace(addrAmount[0]))
-			check(err, "Invalid destination address")
-			outputs[i].Value = parseCurrency(addrAmount[1])
-			recipSum = recipSum.Add(outputs[i].Value)
+// filterByID returns the outputs in utxos whose IDs are in ids. Every ID must
+// correspond to an output in utxos.
+func filterByID(utxos []wallet.UnspentOutput, ids []types.SiacoinOutputID) []wallet.UnspentOutput {
+	byID := make(map[types.SiacoinOutputID]wallet.UnspentOutput, len(utxos))
+	for _, o := range utxos {
+		byID[o.ID] = o
+	}
+	filtered := make([]wallet.UnspentOutput, 0, len(ids))
+	seen := make(map[types.SiacoinOutputID]bool, len(ids))
+	for _, id := range ids {
+		o, ok := byID[id]
+		if !ok {
+			checkUsage(fmt.Errorf("output %v is not in the wallet's set of unspent outputs", id), "Invalid input")
+		} else if !seen[id] {
+			seen[id] = true
+			filtered = append(filtered, o)
 		}
+	}
+	return filtered
+}
 
-		// if using a narwal server, compute donation
-		var donation types.Currency
-		donationAddr, ok := getDonationAddr(*apiAddr)
-		if ok {
-			// donation is max(1%, 10SC)
-			donation = recipSum.MulRat(big.NewRat(1, 100))
-			if tenSC := types.SiacoinPrecision.Mul64(10); donation.Cmp(tenSC) < 0 {
-				donation = tenSC
+// selectInputs narrows inputs to the set that wallet.FundTransaction should
+// choose from, according to strategy. FundTransaction itself spends the
+// largest inputs first, so 'largest-first' returns inputs unchanged;
+// 'smallest-first' returns the smallest inputs that can fund amount, and
+// 'branch-and-bound' searches for the subset that funds amount with the least
+// change. If no suitable subset is found, inputs is returned unchanged.
+func selectInputs(strategy string, inputs []wallet.ValuedInput, outputs []types.SiacoinOutput, amount, feePerByte types.Currency) []wallet.ValuedInput {
+	if len(inputs) == 0 {
+		return inputs
+	}
+	sorted := append([]wallet.ValuedInput(nil), inputs...)
+	switch strategy {
+	case "smallest-first":
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Value.Cmp(sorted[j].Value) < 0
+		})
+		// find the shortest prefix that can fund the transaction. This
+		// assumes that if a prefix suffices, every longer prefix does too,
+		// i.e. that each additional input is worth more than the fee it adds.
+		// FundTransaction may reorder its inputs, so each probe gets a copy.
+		n := sort.Search(len(sorted), func(n int) bool {
+			prefix := append([]wallet.ValuedInput(nil), sorted[:n+1]...)
+			_, _, _, ok := wallet.FundTransaction(amount, feePerByte, prefix)
+			return ok
+		})
+		if n < len(sorted) {
+			return sorted[:n+1]
+		}
+	case "branch-and-bound":
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Value.Cmp(sorted[j].Value) > 0
+		})
+		if sel := branchAndBound(sorted, outputs, amount, feePerByte); sel != nil {
+			if _, _, _, ok := wallet.FundTransaction(amount, feePerByte, sel); ok {
+				return sel
 			}
 		}
+		fmt.Fprintln(infoOut(), "Warning: branch-and-bound found no suitable set of inputs; falling back to largest-first.")
+	}
+	return inputs
+}
 
-		// fund transaction
-		utxos, err := c.UnspentOutputs(true)
-		check(err, "Could not get utxos")
-		inputs := make([]wallet.ValuedInput, len(utxos))
-		for i, o := range utxos {
-			info, err := c.AddressInfo(o.UnlockHash)
-			check(err, "Could not get address info")
-			inputs[i] = wallet.ValuedInput{
-				SiacoinInput: types.SiacoinInput{
-					ParentID:         o.ID,
-					UnlockConditions: info.UnlockConditions,
-				},
-				Value: o.Value,
-			}
+// branchAndBound searches for the subset of inputs (which must be sorted by
+// descending value) whose value exceeds amount plus the estimated fee by the
+// smallest margin. It returns nil if no subset is found within a fixed number
+// of tries.
+func branchAndBound(inputs []wallet.ValuedInput, outputs []types.SiacoinOutput, amount, feePerByte types.Currency) []wallet.ValuedInput {
+	// estimate the size of the transaction, and the size added by each input
+	txn := types.Transaction{
+		SiacoinOutputs: outputs,
+		MinerFees:      []types.Currency{types.ZeroCurrency},
+	}
+	baseSize := txn.MarshalSiaSize()
+	txn.SiacoinInputs = []types.SiacoinInput{inputs[0].SiacoinInput}
+	txn.TransactionSignatures = []types.TransactionSignature{{
+		CoveredFields: types.CoveredFields{WholeTransaction: true},
+		Signature:     make([]byte, 64),
+	}}
+	inputSize := txn.MarshalSiaSize() - baseSize
+
+	// remaining[i] is the total value of inputs[i:]
+	remaining := make([]types.Currency, len(inputs)+1)
+	for i := len(inputs) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1].Add(inputs[i].Value)
+	}
+
+	const maxTries = 100000
+	var best, sel []int
+	var bestExcess types.Currency
+	tries := 0
+	var search func(i int, sum types.Currency)
+	search = func(i int, sum types.Currency) {
+		tries++
+		if tries > maxTries || (best != nil && bestExcess.IsZero()) {
+			return
 		}
-		feePerByte, err := c.RecommendedFee()
-		check(err, "Could not get recommended transaction fee")
-		used, fee, change, ok := wallet.FundTransaction(recipSum.Add(donation), feePerByte, inputs)
-		if !ok {
-			// couldn't afford transaction with donation; try funding without
-			// donation and "donate the change" instead
-			used, fee, change, ok = wallet.FundTransaction(recipSum, feePerByte, inputs)
-			if !ok {
-				check(errors.New("insufficient funds"), "Could not create transaction")
+		target := amount.Add(feePerByte.Mul64(uint64(baseSize + len(sel)*inputSize)))
+		if sum.Cmp(target) >= 0 {
+			if excess := sum.Sub(target); best == nil || excess.Cmp(bestExcess) < 0 {
+				best, bestExcess = append([]int(nil), sel...), excess
 			}
-			donation, change = change, types.ZeroCurrency
-		}
-		if !donation.IsZero() {
-			outputs = append(outputs, types.SiacoinOutput{
-				UnlockHash: donationAddr,
-				Value:      donation,
-			})
+			return
+		} else if i == len(inputs) || sum.Add(remaining[i]).Cmp(target) < 0 {
+			return
+		} else if best != nil && sum.Add(inputs[i].Value).Cmp(target.Add(bestExcess)) > 0 {
+			// including this input would overshoot the best solution; try
+			// the smaller inputs instead
+			search(i+1, sum)
+			return
 		}
+		sel = append(sel, i)
+		search(i+1, sum.Add(inputs[i].Value))
+		sel = sel[:len(sel)-1]
+		search(i+1, sum)
+	}
+	search(0, types.ZeroCurrency)
+	if best == nil {
+		return nil
+	}
+	used := make([]wallet.ValuedInput, len(best))
+	for i, j := range best {
+		used[i] = inputs[j]
+	}
+	return used
+}
 
-		// add change (if there is any)
-		if !change.IsZero() {
-			var changeAddr types.UnlockHash
-			if changeAddrStr != "" {
-				err = changeAddr.LoadString(changeAddrStr)
-				check(err, "Could not parse change address")
-			} else {
-				changeAddr = getChangeFlow(c, *ledger)
-			}
-			outputs = append(outputs, types.SiacoinOutput{
-				Value:      change,
-				UnlockHash: changeAddr,
-			})
-		}
-		txn := types.Transaction{
-			SiacoinInputs:  make([]types.SiacoinInput, len(used)),
-			SiacoinOutputs: outputs,
-			MinerFees:      []types.Currency{fee},
-		}
-		var inputSum types.Currency
-		for i, in := range used {
-			txn.SiacoinInputs[i] = in.SiacoinInput
-			inputSum = inputSum.Add(in.Value)
-		}
-		fmt.Println("Transaction summary:")
-		fmt.Printf("- %v input%v, totalling %v\n", len(used), plural(len(used)), currencyUnits(inputSum))
-		fmt.Printf("- %v recipient%v, totalling %v\n", len(pairs), plural(len(pairs)), currencyUnits(recipSum))
-		if !donation.IsZero() {
-			fmt.Printf("- A donation of %v to the narwal server\n", currencyUnits(donation))
-		}
-		fmt.Printf("- A miner fee of %v, which is %v/byte\n", currencyUnits(fee), currencyUnits(feePerByte))
-		if !change.IsZero() {
-			fmt.Printf("- A change output, sending %v back to your wallet\n", currencyUnits(change))
-		}
-		fmt.Println()
+// estimateSignedSize returns the approximate encoded size of txn once a
+// standard signature has been added for each of its inputs.
+func estimateSignedSize(txn types.Transaction) int {
+	txn.TransactionSignatures = append([]types.TransactionSignature(nil), txn.TransactionSignatures...)
+	for len(txn.TransactionSignatures) < len(txn.SiacoinInputs) {
+		txn.TransactionSignatures = append(txn.TransactionSignatures, types.TransactionSignature{
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+			Signature:     make([]byte, 64),
+		})
+	}
+	return txn.MarshalSiaSize()
+}
 
-		if sign {
-			if *ledger {
-				err := signFlowCold(c, &txn)
-				check(err, "Could not sign transaction")
-			} else {
-				err := signFlowHot(c, &txn)
-				check(err, "Could not sign transaction")
-			}
-		} else {
-			fmt.Println("Transaction has not been signed. You can sign it with the 'sign' command.")
+// changeOutputIndex returns the index of the change output of txn, or -1 if
+// there is none.
+func changeOutputIndex(txn types.Transaction, changeAddr types.UnlockHash, change types.Currency) int {
+	if change.IsZero() {
+		return -1
+	}
+	for i, o := range txn.SiacoinOutputs {
+		if o.UnlockHash == changeAddr && o.Value.Cmp(change) == 0 {
+			return i
 		}
+	}
+	return -1
+}
 
-		if broadcast {
-			err := broadcastFlow(c, txn)
-			check(err, "Could not broadcast transaction")
-			return
-		}
+// dustThreshold returns the fee required to spend a standard input at
+// feePerByte. An output worth less than this costs more to spend than it is
+// worth.
+func dustThreshold(feePerByte types.Currency) types.Currency {
+	inputSize := estimateTxnSize(2, 1) - estimateTxnSize(1, 1)
+	return feePerByte.Mul64(uint64(inputSize))
+}
 
-		writeTxn(args[1], txn)
-		if sign {
-			fmt.Println("Wrote signed transaction to", args[1])
-		} else {
-			fmt.Println("Wrote unsigned transaction to", args[1])
+// estimateTxnSize returns the approximate encoded size of a signed transaction
+// spending numInputs standard inputs to numOutputs outputs.
+func estimateTxnSize(numInputs, numOutputs int) int {
+	uc := wallet.StandardUnlockConditions(types.SiaPublicKey{
+		Algorithm: types.SignatureEd25519,
+		Key:       make([]byte, 32),
+	})
+	txn := types.Transaction{
+		SiacoinInputs:         make([]types.SiacoinInput, numInputs),
+		SiacoinOutputs:        make([]types.SiacoinOutput, numOutputs),
+		MinerFees:             []types.Currency{types.SiacoinPrecision},
+		TransactionSignatures: make([]types.TransactionSignature, numInputs),
+	}
+	for i := range txn.SiacoinInputs {
+		txn.SiacoinInputs[i].UnlockConditions = uc
+		txn.TransactionSignatures[i] = types.TransactionSignature{
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+			Signature:     make([]byte, 64),
 		}
+	}
+	for i := range txn.SiacoinOutputs {
+		txn.SiacoinOutputs[i].Value = types.SiacoinPrecision.Mul64(1000)
+	}
+	return txn.MarshalSiaSize()
+}
 
-	case splitCmd:
-		if !((len(args) == 3) || (len(args) == 2 && broadcast)) {
-			cmd.Usage()
-			return
+// checkNonEmpty aborts with a helpful message if the wallet has no spendable
+// outputs.
+func checkNonEmpty(utxos []wallet.UnspentOutput) {
+	if len(utxos) == 0 {
+		fatalf("The wallet has no spendable outputs. Use the 'addr' command to generate an address, and send some coins to it before creating a transaction.")
+	}
+}
+
+// smallestOutput returns the least valuable output in utxos, which must not be
+// empty.
+func smallestOutput(utxos []wallet.UnspentOutput) wallet.UnspentOutput {
+	smallest := utxos[0]
+	for _, o := range utxos[1:] {
+		if o.Value.Cmp(smallest.Value) < 0 {
+			smallest = o
 		}
-		// parse
-		n, err := strconv.Atoi(args[0])
-		check(err, "Could not parse number of outputs")
-		per := parseCurrency(args[1])
+	}
+	return smallest
+}
 
-		// fetch utxos and fee
-		utxos, err := c.UnspentOutputs(true)
-		check(err, "Could not get utxos")
-		feePerByte, err := c.RecommendedFee()
-		check(err, "Could not get recommended transaction fee")
+// defragEstimate returns the total value of ins, the fee for a transaction of
+// the specified size spending them, and the net amount remaining after the
+// fee. It returns an error if the fee would consume the entire total.
+func defragEstimate(ins []wallet.UnspentOutput, size int, feePerByte types.Currency) (total, fee, net types.Currency, err error) {
+	total = wallet.SumOutputs(ins)
+	fee = feePerByte.Mul64(uint64(size))
+	if fee.Cmp(total) >= 0 {
+		return total, fee, types.ZeroCurrency, fundsError{"miner fee exceeds value of inputs"}
+	}
+	return total, fee, total.Sub(fee), nil
+}
 
-		ins, fee, change := wallet.DistributeFunds(utxos, n, per, feePerByte)
-		if len(ins) == 0 {
-			check(errors.New("insufficient funds"), "Could not create split transaction")
+// dustOutputs returns the outputs in utxos worth less than threshold, most
+// valuable first. At most limit outputs are returned; if there are more, the
+// most valuable are used.
+func dustOutputs(utxos []wallet.UnspentOutput, threshold types.Currency, limit int) []wallet.UnspentOutput {
+	var dust []wallet.UnspentOutput
+	for _, u := range utxos {
+		if u.Value.Cmp(threshold) < 0 {
+			dust = append(dust, u)
 		}
+	}
+	sort.Slice(dust, func(i, j int) bool {
+		return dust[i].Value.Cmp(dust[j].Value) > 0
+	})
+	if len(dust) > limit {
+		dust = dust[:limit]
+	}
+	return dust
+}
 
-		// get change output
-		var changeAddr types.UnlockHash
-		if changeAddrStr != "" {
-			err = changeAddr.LoadString(changeAddrStr)
-			check(err, "Could not parse change address")
-		} else {
-			changeAddr = getChangeFlow(c, *ledger)
-		}
+// largestOutputs returns the n most valuable outputs in utxos.
+func largestOutputs(utxos []wallet.UnspentOutput, n int) []wallet.UnspentOutput {
+	if len(utxos) <= n {
+		return utxos
+	}
+	sorted := append([]wallet.UnspentOutput(nil), utxos...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Value.Cmp(sorted[j].Value) > 0
+	})
+	return sorted[:n]
+}
 
-		// create txn
-		txn := types.Transaction{
-			SiacoinInputs:  make([]types.SiacoinInput, len(ins)),
-			SiacoinOutputs: make([]types.SiacoinOutput, n, n+1),
-			MinerFees:      []types.Currency{fee},
-		}
-		for i, o := range ins {
-			info, err := c.AddressInfo(o.UnlockHash)
-			check(err, "Could not get address info")
-			txn.SiacoinInputs[i] = types.SiacoinInput{
-				ParentID:         o.ID,
-				UnlockConditions: info.UnlockConditions,
-			}
+// checkFeeCap aborts if the miner fees of txn exceed feeCap. A zero feeCap
+// disables the check.
+func checkFeeCap(txn types.Transaction, feeCap types.Currency) {
+	if feeCap.IsZero() {
+		return
+	}
+	var fees types.Currency
+	for _, fee := range txn.MinerFees {
+		fees = fees.Add(fee)
+	}
+	if fees.Cmp(feeCap) > 0 {
+		check(fmt.Errorf("miner fee of %v exceeds the fee cap of %v (use -fee-cap to raise or disable the cap)",
+			currencyUnits(fees), currencyUnits(feeCap)), "Could not create transaction")
+	}
+}
+
+// maxSplitOutputs returns the largest n for which wallet.DistributeFunds can
+// fund n outputs of value per, along with the resulting fee and change.
+func maxSplitOutputs(utxos []wallet.UnspentOutput, per, feePerByte types.Currency) (n int, fee, change types.Currency) {
+	if per.IsZero() {
+		return 0, types.ZeroCurrency, types.ZeroCurrency
+	}
+	upper := wallet.SumOutputs(utxos).Div(per)
+	if upper.Cmp64(math.MaxInt32) > 0 {
+		upper = types.NewCurrency64(math.MaxInt32)
+	}
+	n = sort.Search(int(upper.Big().Int64()), func(i int) bool {
+		ins, _, _ := wallet.DistributeFunds(utxos, i+1, per, feePerByte)
+		return len(ins) == 0
+	})
+	if n == 0 {
+		return 0, types.ZeroCurrency, types.ZeroCurrency
+	}
+	_, fee, change = wallet.DistributeFunds(utxos, n, per, feePerByte)
+	return n, fee, change
+}
+
+// gapScanFlow derives addresses from seed until it finds gapLimit consecutive
+// addresses that do not appear in the wallet's history, and returns the index
+// of the first such address.
+func gapScanFlow(c *walrus.Client, seed wallet.Seed, gapLimit int) uint64 {
+	if gapLimit <= 0 {
+		check(errors.New("gap limit must be positive"), "Could not scan addresses")
+	}
+	used := make(map[types.UnlockHash]bool)
+	_, txns := fetchTransactions(c)
+	for _, txn := range txns {
+		for _, in := range txn.Transaction.SiacoinInputs {
+			used[in.UnlockConditions.UnlockHash()] = true
 		}
-		for i := range txn.SiacoinOutputs {
-			txn.SiacoinOutputs[i] = types.SiacoinOutput{
-				UnlockHash: changeAddr,
-				Value:      per,
-			}
+		for _, o := range txn.Transaction.SiacoinOutputs {
+			used[o.UnlockHash] = true
 		}
-		if !change.IsZero() {
-			txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
-				UnlockHash: changeAddr,
-				Value:      change,
-			})
+	}
+	utxos, err := c.UnspentOutputs(false)
+	check(err, "Could not get utxos")
+	for _, o := range utxos {
+		used[o.UnlockHash] = true
+	}
+
+	var next uint64
+	for index, gap := uint64(0), 0; gap < gapLimit; index++ {
+		if used[wallet.StandardAddress(seed.PublicKey(index))] {
+			next, gap = index+1, 0
+		} else {
+			gap++
 		}
+	}
+	return next
+}
 
-		fmt.Println("Transaction summary:")
-		fmt.Printf("- %v input%v, totalling %v\n", len(ins), plural(len(ins)), currencyUnits(wallet.SumOutputs(ins)))
-		fmt.Printf("- %v outputs, each worth %v, totalling %v\n", n, per, currencyUnits(per.Mul64(uint64(n))))
-		fmt.Printf("- A miner fee of %v, which is %v/byte\n", currencyUnits(fee), currencyUnits(feePerByte))
-		if !change.IsZero() {
-			fmt.Printf("- A change output, containing the remaining %v\n", currencyUnits(change))
+// verifyAddressFlow re-derives the address at index and compares it to the
+// address the server associates with that index.
+func verifyAddressFlow(c *walrus.Client, index uint64, ledger bool) {
+	var pubkey types.SiaPublicKey
+	if ledger {
+		fmt.Printf("Please verify and accept the prompt on your device to generate address #%v.\n", index)
+		var err error
+		_, pubkey, err = getNanoS().GetAddress(uint32(index), false)
+		check(err, "Could not generate address")
+		fmt.Println("Address derived on device:")
+	} else {
+		pubkey = getSeed().PublicKey(index)
+		fmt.Println("Address derived from seed:")
+	}
+	derived := wallet.StandardAddress(pubkey)
+	fmt.Println("    " + derived.String())
+
+	addrs, err := c.Addresses()
+	check(err, "Could not get address list")
+	var reported []types.UnlockHash
+	for _, addr := range addrs {
+		info, err := c.AddressInfo(addr)
+		check(err, "Could not get address info")
+		if info.KeyIndex == index {
+			reported = append(reported, info.UnlockConditions.UnlockHash())
 		}
-		fmt.Println()
+	}
+	if len(reported) == 0 {
+		fmt.Printf("The server is not tracking any address with key index %v.\n", index)
+		return
+	}
+	fmt.Printf("Address%v reported by server for key index %v:\n", plural(len(reported)), index)
+	mismatch := false
+	for _, addr := range reported {
+		fmt.Println("    " + addr.String())
+		mismatch = mismatch || addr != derived
+	}
+	if mismatch {
+		fatalf("WARNING: the server reported an address that does not match the derived address! Do not send funds to it.")
+	}
+	fmt.Println("The derived address matches the server's address.")
+}
 
-		if sign {
-			if *ledger {
-				err := signFlowCold(c, &txn)
-				check(err, "Could not sign transaction")
-			} else {
-				err := signFlowHot(c, &txn)
-				check(err, "Could not sign transaction")
+// addrBalanceFlow reports the balance and number of unspent outputs of addr.
+func addrBalanceFlow(c *walrus.Client, addr types.UnlockHash) {
+	fmt.Println("Address:", addr)
+	if _, err := c.AddressInfo(addr); err != nil {
+		fmt.Println("This address is not tracked by the wallet, so its balance is unknown.")
+		return
+	}
+	utxos, err := c.UnspentOutputs(true)
+	check(err, "Could not get utxos")
+	utxos = filterByAddress(utxos, addr)
+	fmt.Printf("Balance: %v (%v unspent output%v)\n", currencyUnits(wallet.SumOutputs(utxos)), len(utxos), plural(len(utxos)))
+}
+
+// getChangeAddr returns the address that change should be sent to, according
+// to the specified strategy. The "new" strategy generates a new address
+// (unless changeAddrStr is provided), "specified" uses changeAddrStr, and
+// "reuse-smallest" reuses smallestAddr, the address of the least valuable
+// input being spent.
+func getChangeAddr(c *walrus.Client, strategy, changeAddrStr string, smallestAddr types.UnlockHash, ledger bool) types.UnlockHash {
+	var changeAddr types.UnlockHash
+	switch strategy {
+	case "new", "specified":
+		if changeAddrStr != "" {
+			var err error
+			changeAddr, err = parseAddress(changeAddrStr)
+			check(err, "Could not parse change address")
+			if txids, err := c.TransactionsByAddress(changeAddr, 1); err == nil && len(txids) > 0 {
+				warnReuse("Note that the change address has been used before; reusing addresses can compromise your privacy.")
 			}
+		} else if strategy == "specified" {
+			check(errors.New("the 'specified' change strategy requires the -change flag"), "Could not get change address")
 		} else {
-			fmt.Println("Transaction has not been signed. You can sign it with the 'sign' command.")
+			changeAddr = getChangeFlow(c, ledger)
 		}
+	case "reuse-smallest":
+		fmt.Fprintln(infoOut(), "Sending change to the address of the smallest input:")
+		fmt.Fprintln(infoOut(), "    "+smallestAddr.String())
+		warnReuse("Note that reusing addresses can compromise your privacy.")
+		fmt.Fprintln(infoOut())
+		changeAddr = smallestAddr
+	default:
+		check(fmt.Errorf("unknown change strategy %q", strategy), "Could not get change address")
+	}
+	return changeAddr
+}
 
-		if broadcast {
-			err := broadcastFlow(c, txn)
-			check(err, "Could not broadcast transaction")
-			return
-		}
+// changeWindow is the duration within which a recently-generated change
+// address is reused by getChangeFlow. If zero, a new address is always
+// generated.
+var changeWindow time.Duration
 
-		writeTxn(args[2], txn)
-		if sign {
-			fmt.Println("Wrote signed transaction to", args[2])
-		} else {
-			fmt.Println("Wrote unsigned transaction to", args[2])
+// A changeRecord records the most recently generated change address.
+type changeRecord struct {
+	Address  types.UnlockHash `json:"address"`
+	KeyIndex uint64           `json:"keyIndex"`
+	Created  time.Time        `json:"created"`
+}
+
+// changeRecordPath returns the path of the file storing the most recent change
+// address.
+func changeRecordPath() string {
+	dir, err := os.UserConfigDir()
+	check(err, "Could not locate config directory")
+	return filepath.Join(dir, "walrus-cli", "change.json")
+}
+
+// An addressInfoClient looks up the wallet's record of an address. It is
+// satisfied by *walrus.Client.
+type addressInfoClient interface {
+	AddressInfo(addr types.UnlockHash) (wallet.SeedAddressInfo, error)
+}
+
+// recentChangeAddr returns the most recently generated change address, if it
+// was generated within changeWindow and is still tracked by the wallet.
+func recentChangeAddr(c addressInfoClient) (types.UnlockHash, bool) {
+	js, err := ioutil.ReadFile(changeRecordPath())
+	if err != nil {
+		return types.UnlockHash{}, false
+	}
+	var rec changeRecord
+	if json.Unmarshal(js, &rec) != nil || time.Since(rec.Created) > changeWindow {
+		return types.UnlockHash{}, false
+	}
+	if info, err := c.AddressInfo(rec.Address); err != nil || info.KeyIndex != rec.KeyIndex {
+		return types.UnlockHash{}, false
+	}
+	return rec.Address, true
+}
+
+func saveChangeRecord(rec changeRecord) {
+	path := changeRecordPath()
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	check(err, "Could not create config directory")
+	js, _ := json.MarshalIndent(rec, "", "  ")
+	js = append(js, '\n')
+	err = ioutil.WriteFile(path, js, 0600)
+	check(err, "Could not write change address record")
+}
+
+func getChangeFlow(c *walrus.Client, ledger bool) types.UnlockHash {
+	if changeWindow > 0 {
+		if addr, ok := recentChangeAddr(c); ok {
+			fmt.Fprintln(infoOut(), "Reusing a change address generated within the last", changeWindow.String()+":")
+			fmt.Fprintln(infoOut(), "    "+addr.String())
+			warnReuse("Note that reusing addresses can compromise your privacy.")
+			fmt.Fprintln(infoOut())
+			return addr
 		}
+	}
+	var pubkey types.SiaPublicKey
+	fmt.Fprintln(infoOut(), "This transaction requires a 'change output' that will send excess coins back to your wallet.")
+	index, err := c.SeedIndex()
+	check(err, "Could not get next seed index")
+	if ledger {
+		fmt.Fprintln(infoOut(), "Please verify and accept the prompt on your device to generate a change address.")
+		fmt.Fprintln(infoOut(), "(You may use the --change flag to specify a change address in advance.)")
+		_, pubkey, err = getNanoS().GetAddress(uint32(index), false)
+		check(err, "Could not generate address")
+		fmt.Fprintln(infoOut(), "Compare the address displayed on your device to the address below:")
+		fmt.Fprintln(infoOut(), "    "+wallet.StandardAddress(pubkey).String())
+	} else {
+		pubkey = getSeed().PublicKey(index)
+		fmt.Fprintln(infoOut(), "Derived address from seed:")
+		fmt.Fprintln(infoOut(), "    "+wallet.StandardAddress(pubkey).String())
+	}
+	fmt.Fprint(infoOut(), "Press ENTER to add this address to your wallet, or Ctrl-C to cancel.")
+	bufio.NewReader(os.Stdin).ReadLine()
+	info := wallet.SeedAddressInfo{
+		UnlockConditions: wallet.StandardUnlockConditions(pubkey),
+		KeyIndex:         index,
+	}
+	err = c.AddAddress(info)
+	check(err, "Could not add address to wallet")
+	state.newChangeAddrs = append(state.newChangeAddrs, info)
+	if changeWindow > 0 {
+		saveChangeRecord(changeRecord{wallet.StandardAddress(pubkey), index, time.Now()})
+	}
+	fmt.Fprintln(infoOut(), "Change address added successfully.")
+	fmt.Fprintln(infoOut())
+	return wallet.StandardAddress(pubkey)
+}
 
-	case defragCmd:
-		if !((len(args) == 2) || (len(args) == 1 && broadcast)) {
-			cmd.Usage()
-			return
+// broadcastErrors maps fragments of common transaction pool rejection
+// messages to advice for the user.
+var broadcastErrors = []struct {
+	fragment string
+	advice   string
+}{
+	{"nonexisting siacoin output", "One or more inputs do not exist. They may have already been spent, or they may belong to an unconfirmed parent transaction that must be broadcast first."},
+	{"miner fee", "The miner fee is too low for the transaction to be accepted. Create a new transaction with a higher fee."},
+	{"conflicts with", "The transaction spends outputs that are already spent by another unconfirmed transaction."},
+	// siad's ErrMissingSignatures and crypto.ErrInvalidSignature
+	{"inputs with missing signatures", "One or more signatures are missing. Make sure every input was signed by the wallet that controls it."},
+	{"invalid signature", "One or more signatures are invalid. Make sure the transaction was signed by the correct wallet and not modified afterwards."},
+	{"timelock", "One or more inputs or signatures are timelocked and cannot be spent until a later block height."},
+}
+
+// explainBroadcastError annotates err with advice, if err matches a known
+// rejection reason.
+func explainBroadcastError(err error) error {
+	msg := strings.ToLower(err.Error())
+	for _, be := range broadcastErrors {
+		if strings.Contains(msg, be.fragment) {
+			return fmt.Errorf("%v\n\n%v", err, be.advice)
 		}
-		// parse
-		min := parseCurrency(args[0])
+	}
+	return err
+}
 
-		// fetch utxos and fee
-		utxos, err := c.UnspentOutputs(true)
-		check(err, "Could not get utxos")
-		feePerByte, err := c.RecommendedFee()
-		check(err, "Could not get recommended transaction fee")
+// isDuplicateError reports whether err indicates that the transaction has
+// already been accepted by the network.
+func isDuplicateError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "duplicate transaction") || strings.Contains(msg, "already in the blockchain")
+}
 
-		// sort by value (descending)
-		sort.Slice(utxos, func(i, j int) bool {
-			return utxos[i].Value.Cmp(utxos[j].Value) > 0
-		})
+// isNetworkError reports whether err was caused by a failure to communicate
+// with the server, rather than a rejection by the server. In that case, the
+// server may or may not have received the transaction.
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
 
-		var ins []wallet.UnspentOutput
-		for i, u := range utxos {
-			if u.Value.Cmp(min) < 0 {
-				ins = utxos[i:]
-				break
-			}
+// txnsPresent reports whether the server knows of every transaction in txns,
+// either as confirmed or unconfirmed wallet transactions.
+func txnsPresent(c *walrus.Client, txns []types.Transaction) bool {
+	for _, txn := range txns {
+		if _, err := c.Transaction(txn.ID()); err != nil {
+			return false
 		}
-		if len(ins) > 100 {
-			// use the 100 most valuable
-			ins = ins[:100]
-		}
-		total := wallet.SumOutputs(ins)
+	}
+	return true
+}
 
-		// get change output
-		var changeAddr types.UnlockHash
-		if changeAddrStr != "" {
-			err = changeAddr.LoadString(changeAddrStr)
-			check(err, "Could not parse change address")
+// checkBroadcast exits if err, the result of broadcasting a transaction, is
+// non-nil. Before exiting, it reports any change addresses that were added to
+// the wallet for the transaction, or removes them from the wallet if untrack
+// is set, so that their key indices do not silently become gaps.
+func checkBroadcast(c *walrus.Client, err error, untrack bool) {
+	if err == nil {
+		return
+	}
+	for _, info := range state.newChangeAddrs {
+		addr := info.UnlockConditions.UnlockHash()
+		if !untrack {
+			fmt.Fprintf(os.Stderr, "Change address #%v (%v) was added to the wallet, but the transaction was not broadcast.\n", info.KeyIndex, addr)
+			fmt.Fprintln(os.Stderr, "If you do not retry the transaction, this leaves a gap in the wallet's key indices. Use -untrack-on-failure to remove the address automatically.")
+		} else if rerr := c.RemoveAddress(addr); rerr != nil {
+			fmt.Fprintf(os.Stderr, "Could not remove change address #%v (%v) from the wallet: %v\n", info.KeyIndex, addr, rerr)
 		} else {
-			changeAddr = getChangeFlow(c, *ledger)
+			fmt.Fprintf(os.Stderr, "Removed change address #%v (%v) from the wallet.\n", info.KeyIndex, addr)
 		}
+	}
+	check(err, "Could not broadcast transaction")
+}
 
-		// create txn
-		txn := types.Transaction{
-			SiacoinInputs: make([]types.SiacoinInput, len(ins)),
-			SiacoinOutputs: []types.SiacoinOutput{{
-				UnlockHash: changeAddr,
-				Value:      types.SiacoinPrecision, // placeholder, for fee calculation
-			}},
-			MinerFees: []types.Currency{types.SiacoinPrecision}, // placeholder, for fee calculation
+// checkSetOrder returns an error if any transaction in txns spends an output
+// created by a later transaction in the set.
+func checkSetOrder(txns []types.Transaction) error {
+	creator := make(map[types.SiacoinOutputID]int)
+	for i, txn := range txns {
+		for j := range txn.SiacoinOutputs {
+			creator[txn.SiacoinOutputID(uint64(j))] = i
 		}
-		for i, o := range ins {
-			info, err := c.AddressInfo(o.UnlockHash)
-			check(err, "Could not get address info")
-			txn.SiacoinInputs[i] = types.SiacoinInput{
-				ParentID:         o.ID,
-				UnlockConditions: info.UnlockConditions,
+	}
+	for i, txn := range txns {
+		for _, in := range txn.SiacoinInputs {
+			if parent, ok := creator[in.ParentID]; ok && parent > i {
+				return fmt.Errorf("transaction %v spends an output of transaction %v, which must come first", i+1, parent+1)
 			}
 		}
-		txn.MinerFees[0] = feePerByte.Mul64(uint64(txn.MarshalSiaSize()))
-		if txn.MinerFees[0].Cmp(total) >= 0 {
-			check(errors.New("miner fee exceeds value of inputs"), "Could not create defrag transaction")
-		}
-		txn.SiacoinOutputs[0].Value = total.Sub(txn.MinerFees[0])
-
-		fmt.Println("Transaction summary:")
-		fmt.Printf("- %v input%v, totalling %v\n", len(ins), plural(len(ins)), currencyUnits(total))
-		fmt.Printf("- 1 change output, totalling %v\n", currencyUnits(txn.SiacoinOutputs[0].Value))
-		fmt.Printf("- A miner fee of %v, which is %v/byte\n", currencyUnits(txn.MinerFees[0]), currencyUnits(feePerByte))
-		fmt.Println()
+	}
+	return nil
+}
 
-		if sign {
-			if *ledger {
-				err := signFlowCold(c, &txn)
-				check(err, "Could not sign transaction")
-			} else {
-				err := signFlowHot(c, &txn)
-				check(err, "Could not sign transaction")
-			}
-		} else {
-			fmt.Println("Transaction has not been signed. You can sign it with the 'sign' command.")
+func broadcastFlow(c *walrus.Client, txns ...types.Transaction) error {
+	var fees types.Currency
+	for _, txn := range txns {
+		for _, fee := range txn.MinerFees {
+			fees = fees.Add(fee)
 		}
+	}
+	if fees.IsZero() && !yes {
+		fmt.Fprintln(infoOut(), "Warning: this transaction does not pay a miner fee, so it is unlikely to ever be confirmed.")
+		fmt.Fprint(infoOut(), "Press ENTER to broadcast it anyway, or Ctrl-C to cancel.")
+		bufio.NewReader(os.Stdin).ReadLine()
+		fmt.Fprintln(infoOut())
+	}
+	err := c.Broadcast(txns)
+	if err != nil && isNetworkError(err) && txnsPresent(c, txns) {
+		fmt.Fprintln(infoOut(), "The connection to the server failed during broadcast, but the server now reports")
+		fmt.Fprintln(infoOut(), "the transaction, so it was received. No further action is needed.")
+	} else if err != nil && isDuplicateError(err) {
+		fmt.Fprintln(infoOut(), "The server reported that this transaction has already been broadcast or confirmed.")
+		fmt.Fprintln(infoOut(), "No further action is needed. (Server response:", err.Error()+")")
+	} else if err != nil {
+		return explainBroadcastError(err)
+	} else if len(txns) == 1 {
+		fmt.Fprintln(infoOut(), "Transaction broadcast successfully.")
+	} else {
+		fmt.Fprintf(infoOut(), "Transaction set (%v transactions) broadcast successfully.\n", len(txns))
+	}
+	if len(txns) == 1 {
+		fmt.Println("Transaction ID:", txns[0].ID())
+		return nil
+	}
+	fmt.Println("Transaction IDs:")
+	for _, txn := range txns {
+		fmt.Println("   ", txn.ID())
+	}
+	return nil
+}
 
-		if broadcast {
-			err := broadcastFlow(c, txn)
-			check(err, "Could not broadcast transaction")
-			return
-		}
+// checkFeeRate warns if the fee rate of txn differs dramatically from the
+// server's recommended fee rate.
+func checkFeeRate(c *walrus.Client, txn types.Transaction) {
+	recommended, err := c.RecommendedFee()
+	if err != nil || recommended.IsZero() {
+		fmt.Fprintln(infoOut(), "(Could not compare the miner fee to the recommended fee.)")
+		return
+	}
+	var fees types.Currency
+	for _, fee := range txn.MinerFees {
+		fees = fees.Add(fee)
+	}
+	// measure the rate against the signed size, since that is what miners see
+	rate := fees.Div64(uint64(estimateSignedSize(txn)))
+	if rate.Mul64(2).Cmp(recommended) < 0 {
+		fmt.Fprintf(infoOut(), "WARNING: the miner fee is %v/byte, which is far below the recommended %v/byte. The transaction may never be confirmed.\n",
+			currencyUnits(rate), currencyUnits(recommended))
+	} else if rate.Cmp(recommended.Mul64(10)) > 0 {
+		fmt.Fprintf(infoOut(), "WARNING: the miner fee is %v/byte, which is far above the recommended %v/byte. You may be overpaying.\n",
+			currencyUnits(rate), currencyUnits(recommended))
+	}
+}
 
-		writeTxn(args[1], txn)
-		if sign {
-			fmt.Println("Wrote signed transaction to", args[1])
-		} else {
-			fmt.Println("Wrote unsigned transaction to", args[1])
+// walletInputIndices returns the indices of the inputs of txn that spend
+// outputs owned by the wallet.
+func walletInputIndices(txn types.Transaction, owned map[types.UnlockHash]bool) []int {
+	var indices []int
+	for i, in := range txn.SiacoinInputs {
+		if owned[in.UnlockConditions.UnlockHash()] {
+			indices = append(indices, i)
 		}
+	}
+	return indices
+}
 
-	case signCmd:
-		if len(args) != 1 {
-			cmd.Usage()
-			return
+// unsignedInputs returns the subset of the specified input indices of txn
+// that have no completed signature.
+func unsignedInputs(txn types.Transaction, indices []int) []int {
+	signed := make(map[crypto.Hash]bool)
+	for _, sig := range txn.TransactionSignatures {
+		if len(sig.Signature) > 0 {
+			signed[sig.ParentID] = true
 		}
-		txn := readTxn(args[0])
-		if *ledger {
-			err := signFlowCold(c, &txn)
-			check(err, "Could not sign transaction")
-		} else {
-			err := signFlowHot(c, &txn)
-			check(err, "Could not sign transaction")
+	}
+	var missing []int
+	for _, i := range indices {
+		if !signed[crypto.Hash(txn.SiacoinInputs[i].ParentID)] {
+			missing = append(missing, i)
 		}
+	}
+	return missing
+}
 
-		if broadcast {
-			err := broadcastFlow(c, txn)
-			check(err, "Could not broadcast transaction")
-		} else {
-			ext := filepath.Ext(args[0])
-			signedPath := strings.TrimSuffix(args[0], ext) + "-signed" + ext
-			writeTxn(signedPath, txn)
-			fmt.Println("Wrote signed transaction to", signedPath+".")
-			fmt.Println("You can now use the 'broadcast' command to broadcast this transaction.")
-		}
+// walletInputKeys returns the key index of each of the specified inputs of
+// txn, exiting with an error if any input is not controlled by the wallet.
+func walletInputKeys(c *walrus.Client, txn types.Transaction, indices []int) map[int]uint64 {
+	keys := make(map[int]uint64, len(indices))
+	for _, i := range indices {
+		info, err := c.AddressInfo(txn.SiacoinInputs[i].UnlockConditions.UnlockHash())
+		check(err, fmt.Sprintf("Input %v is not controlled by this wallet", i))
+		keys[i] = info.KeyIndex
+	}
+	return keys
+}
 
-	case broadcastCmd:
-		if len(args) != 1 {
-			cmd.Usage()
-			return
-		}
-		err := broadcastFlow(c, readTxn(args[0]))
-		check(err, "Could not broadcast transaction")
+// newSignature returns a signature entry covering the whole transaction for
+// the input with the specified parent ID, timelocked to sigTimelock.
+func newSignature(id crypto.Hash) types.TransactionSignature {
+	sig := wallet.StandardTransactionSignature(id)
+	sig.Timelock = state.sigTimelock
+	return sig
+}
 
-	case transactionsCmd:
-		if len(args) != 0 {
-			cmd.Usage()
-			return
+// checkKeyTypes returns an error if any of sigs would be made with a key that
+// does not use the Ed25519 signature scheme, since such a signature would be
+// invalid.
+func checkKeyTypes(txn types.Transaction, sigs []types.TransactionSignature) error {
+	inputs := make(map[crypto.Hash]types.UnlockConditions)
+	for _, in := range txn.SiacoinInputs {
+		inputs[crypto.Hash(in.ParentID)] = in.UnlockConditions
+	}
+	for _, sig := range sigs {
+		uc, ok := inputs[sig.ParentID]
+		if !ok || sig.PublicKeyIndex >= uint64(len(uc.PublicKeys)) {
+			continue
 		}
+		if alg := uc.PublicKeys[sig.PublicKeyIndex].Algorithm; alg != types.SignatureEd25519 {
+			return fmt.Errorf("input %v uses an unsupported signature scheme (%q); only ed25519 keys can be signed", sig.ParentID, alg)
+		}
+	}
+	return nil
+}
 
-		txids, err := c.Transactions(-1)
-		check(err, "Could not get transactions")
-		if len(txids) == 0 {
-			fmt.Println("No transactions to display.")
-			return
+// nonStandardSigs returns a signature entry, and the key index that must sign
+// it, for each public key controlled by the wallet within an input of txn whose
+// unlock conditions are not those of a wallet address, such as a multisig
+// input. Key slots that are already signed are skipped.
+func nonStandardSigs(c *walrus.Client, txn types.Transaction) ([]types.TransactionSignature, []uint64) {
+	owned := ownedAddresses(c)
+	signed := make(map[crypto.Hash]map[uint64]bool)
+	for _, sig := range txn.TransactionSignatures {
+		if signed[sig.ParentID] == nil {
+			signed[sig.ParentID] = make(map[uint64]bool)
 		}
-		txns := make([]walrus.ResponseTransactionsID, len(txids))
-		for i, txid := range txids {
-			txns[i], err = c.Transaction(txid)
-			check(err, "Could not get transaction")
+		signed[sig.ParentID][sig.PublicKeyIndex] = true
+	}
+	var sigs []types.TransactionSignature
+	var keys []uint64
+	for _, in := range txn.SiacoinInputs {
+		if owned[in.UnlockConditions.UnlockHash()] {
+			continue // standard input; signed normally
 		}
-		fmt.Println("Transaction ID                                                      Height    Gain/Loss")
-		for i, txn := range txns {
-			var delta string
-			if txn.Debit.IsZero() {
-				delta = "+" + currencyUnits(txn.Credit)
-			} else {
-				delta = "-" + currencyUnits(txn.Debit)
+		for j, pk := range in.UnlockConditions.PublicKeys {
+			addr := wallet.StandardAddress(pk)
+			if !owned[addr] || signed[crypto.Hash(in.ParentID)][uint64(j)] {
+				continue
 			}
-			fmt.Printf("%v  %8v    %v\n", txids[i], txn.BlockHeight, delta)
+			info, err := c.AddressInfo(addr)
+			check(err, "Could not get address info")
+			sigs = append(sigs, types.TransactionSignature{
+				ParentID:       crypto.Hash(in.ParentID),
+				PublicKeyIndex: uint64(j),
+				Timelock:       state.sigTimelock,
+				CoveredFields:  types.CoveredFields{WholeTransaction: true},
+			})
+			keys = append(keys, info.KeyIndex)
 		}
 	}
+	return sigs, keys
 }
 
-func getChangeFlow(c *walrus.Client, ledger bool) types.UnlockHash {
-	var pubkey types.SiaPublicKey
-	fmt.Println("This transaction requires a 'change output' that will send excess coins back to your wallet.")
-	index, err := c.SeedIndex()
-	check(err, "Could not get next seed index")
-	if ledger {
-		fmt.Println("Please verify and accept the prompt on your device to generate a change address.")
-		fmt.Println("(You may use the --change flag to specify a change address in advance.)")
-		_, pubkey, err = getNanoS().GetAddress(uint32(index), false)
-		check(err, "Could not generate address")
-		fmt.Println("Compare the address displayed on your device to the address below:")
-		fmt.Println("    " + wallet.StandardAddress(pubkey).String())
-	} else {
-		pubkey = getSeed().PublicKey(index)
-		fmt.Println("Derived address from seed:")
-		fmt.Println("    " + wallet.StandardAddress(pubkey).String())
+// A parentOutput is an output spent by a transaction, as listed by the utxos
+// command.
+type parentOutput struct {
+	ID      types.SiacoinOutputID `json:"id"`
+	Value   types.Currency        `json:"value"`
+	Address types.UnlockHash      `json:"address"`
+}
+
+// readParentOutputs reads a JSON array of parent outputs from filename.
+func readParentOutputs(filename string) map[types.SiacoinOutputID]parentOutput {
+	js, err := ioutil.ReadFile(filename)
+	check(err, "Could not read parent outputs file")
+	var outputs []parentOutput
+	err = json.Unmarshal(js, &outputs)
+	check(err, "Could not parse parent outputs file")
+	m := make(map[types.SiacoinOutputID]parentOutput, len(outputs))
+	for _, o := range outputs {
+		m[o.ID] = o
 	}
-	fmt.Print("Press ENTER to add this address to your wallet, or Ctrl-C to cancel.")
-	bufio.NewReader(os.Stdin).ReadLine()
-	err = c.AddAddress(wallet.SeedAddressInfo{
-		UnlockConditions: wallet.StandardUnlockConditions(pubkey),
-		KeyIndex:         index,
-	})
-	check(err, "Could not add address to wallet")
-	fmt.Println("Change address added successfully.")
-	fmt.Println()
-	return wallet.StandardAddress(pubkey)
+	return m
 }
 
-func broadcastFlow(c *walrus.Client, txn types.Transaction) error {
-	err := c.Broadcast([]types.Transaction{txn})
-	if err != nil {
-		return err
+// printParentOutputs displays the value of each input of txn, as supplied by
+// parentOutputs, and checks that the inputs balance the outputs and fees. It
+// exits with an error if a parent output does not match the input spending it.
+func printParentOutputs(w io.Writer, txn types.Transaction) {
+	if state.parentOutputs == nil {
+		return
+	}
+	var inputSum types.Currency
+	resolved := true
+	for _, in := range txn.SiacoinInputs {
+		o, ok := state.parentOutputs[in.ParentID]
+		if !ok {
+			fmt.Fprintln(w, "    Spending", in.ParentID, "of unknown value")
+			resolved = false
+			continue
+		} else if o.Address != in.UnlockConditions.UnlockHash() {
+			fatalf("Parent output %v belongs to %v, but the input spending it uses the unlock conditions of %v.", in.ParentID, o.Address, in.UnlockConditions.UnlockHash())
+		}
+		fmt.Fprintln(w, "    Spending", in.ParentID, "worth", currencyUnits(o.Value))
+		inputSum = inputSum.Add(o.Value)
+	}
+	if !resolved {
+		fmt.Fprintln(w, "Some parent outputs are missing from the file, so the fee cannot be verified.")
+		return
+	}
+	var spent types.Currency
+	for _, o := range txn.SiacoinOutputs {
+		spent = spent.Add(o.Value)
+	}
+	for _, fee := range txn.MinerFees {
+		spent = spent.Add(fee)
+	}
+	if inputSum.Cmp(spent) != 0 {
+		fmt.Fprintf(w, "WARNING: the inputs total %v, but the outputs and fees total %v!\n", currencyUnits(inputSum), currencyUnits(spent))
+	} else {
+		fmt.Fprintln(w, "Inputs total", currencyUnits(inputSum)+", matching the outputs and fees.")
 	}
-	fmt.Println("Transaction broadcast successfully.")
-	fmt.Println("Transaction ID:", txn.ID())
-	return nil
 }
 
-func signFlowCold(c *walrus.Client, txn *types.Transaction) error {
+// signFlowCold signs txn using the Nano S. If keyHints is non-empty, it maps
+// input indices to key indices, and only those inputs are signed; otherwise,
+// all wallet-controlled inputs are signed, with key indices supplied by the
+// server.
+func signFlowCold(c *walrus.Client, txn *types.Transaction, keyHints map[int]uint64) error {
 	nanos := getNanoS()
-	addrs, err := c.Addresses()
-	check(err, "Could not get addresses")
-	addrMap := make(map[types.UnlockHash]struct{})
-	for _, addr := range addrs {
-		addrMap[addr] = struct{}{}
-	}
 	sigMap := make(map[int]uint64)
-	for _, in := range txn.SiacoinInputs {
-		addr := in.UnlockConditions.UnlockHash()
-		if _, ok := addrMap[addr]; ok {
-			// get key index
-			info, err := c.AddressInfo(addr)
-			check(err, "Could not get address info")
-			// add signature entry
-			sig := wallet.StandardTransactionSignature(crypto.Hash(in.ParentID))
+	if len(keyHints) > 0 {
+		inputIndices := make([]int, 0, len(keyHints))
+		for inputIndex := range keyHints {
+			if inputIndex >= len(txn.SiacoinInputs) {
+				return fmt.Errorf("key index hint refers to input %v, but transaction only has %v input%v", inputIndex, len(txn.SiacoinInputs), plural(len(txn.SiacoinInputs)))
+			}
+			inputIndices = append(inputIndices, inputIndex)
+		}
+		sort.Ints(inputIndices)
+		for _, inputIndex := range inputIndices {
+			sig := newSignature(crypto.Hash(txn.SiacoinInputs[inputIndex].ParentID))
 			txn.TransactionSignatures = append(txn.TransactionSignatures, sig)
-			sigMap[len(txn.TransactionSignatures)-1] = info.KeyIndex
-			continue
+			sigMap[len(txn.TransactionSignatures)-1] = keyHints[inputIndex]
+		}
+	} else {
+		addrs, err := c.Addresses()
+		check(err, "Could not get addresses")
+		addrMap := make(map[types.UnlockHash]struct{})
+		for _, addr := range addrs {
+			addrMap[addr] = struct{}{}
+		}
+		for _, in := range txn.SiacoinInputs {
+			addr := in.UnlockConditions.UnlockHash()
+			if _, ok := addrMap[addr]; ok {
+				// get key index
+				info, err := c.AddressInfo(addr)
+				check(err, "Could not get address info")
+				// add signature entry
+				sig := newSignature(crypto.Hash(in.ParentID))
+				txn.TransactionSignatures = append(txn.TransactionSignatures, sig)
+				sigMap[len(txn.TransactionSignatures)-1] = info.KeyIndex
+				continue
+			}
+		}
+		sigs, keys := nonStandardSigs(c, *txn)
+		for i, sig := range sigs {
+			txn.TransactionSignatures = append(txn.TransactionSignatures, sig)
+			sigMap[len(txn.TransactionSignatures)-1] = keys[i]
 		}
 	}
 	if len(sigMap) == 0 {
-		fmt.Println("Nothing to sign: transaction does not spend any outputs recognized by this wallet")
+		fmt.Fprintln(infoOut(), "Nothing to sign: transaction does not spend any outputs recognized by this wallet")
 		return nil
 	}
+	pending := make([]types.TransactionSignature, 0, len(sigMap))
+	for sigIndex := range sigMap {
+		pending = append(pending, txn.TransactionSignatures[sigIndex])
+	}
+	if err := checkKeyTypes(*txn, pending); err != nil {
+		return err
+	}
 	// request signatures from device
-	fmt.Println("Please verify the transaction details on your device. You should see:")
+	fmt.Fprintln(infoOut(), "Please verify the transaction details on your device. You should see:")
 	for _, sco := range txn.SiacoinOutputs {
-		fmt.Println("   ", sco.UnlockHash, "receiving", currencyUnits(sco.Value))
+		fmt.Fprintln(infoOut(), "   ", sco.UnlockHash, "receiving", currencyUnits(sco.Value))
 	}
+	printSiafundClaims(infoOut(), *txn)
 	for _, fee := range txn.MinerFees {
-		fmt.Println("    A miner fee of", currencyUnits(fee))
+		fmt.Fprintln(infoOut(), "    A miner fee of", currencyUnits(fee))
 	}
+	printParentOutputs(infoOut(), *txn)
+	checkFeeRate(c, *txn)
 	if len(sigMap) > 1 {
-		fmt.Printf("Each signature must be completed separately, so you will be prompted %v times.\n", len(sigMap))
+		fmt.Fprintf(infoOut(), "Each signature must be completed separately, so you will be prompted %v times.\n", len(sigMap))
 	}
 	for sigIndex, keyIndex := range sigMap {
-		fmt.Printf("Waiting for signature for input %v, key %v...", sigIndex, keyIndex)
+		fmt.Fprintf(infoOut(), "Waiting for signature for input %v, key %v...", sigIndex, keyIndex)
 		sig, err := nanos.SignTxn(*txn, uint16(sigIndex), uint32(keyIndex))
-		check(err, "Could not get signature")
+		checkSigning(err, "Could not get signature")
 		txn.TransactionSignatures[sigIndex].Signature = sig[:]
-		fmt.Println("Done")
+		fmt.Fprintln(infoOut(), "Done")
 	}
 	return nil
 }
 
-func signFlowHot(c *walrus.Client, txn *types.Transaction) error {
+// signFlowHints signs the inputs of txn specified by keyHints, which maps
+// input indices to key indices, using the seed. The server is not contacted.
+func signFlowHints(txn *types.Transaction, keyHints map[int]uint64) error {
 	seed := getSeed()
-	fmt.Println("Please verify the transaction details:")
+	inputIndices := make([]int, 0, len(keyHints))
+	for inputIndex, keyIndex := range keyHints {
+		if inputIndex >= len(txn.SiacoinInputs) {
+			return fmt.Errorf("key index hint refers to input %v, but transaction only has %v input%v", inputIndex, len(txn.SiacoinInputs), plural(len(txn.SiacoinInputs)))
+		} else if txn.SiacoinInputs[inputIndex].UnlockConditions.UnlockHash() != wallet.StandardAddress(seed.PublicKey(keyIndex)) {
+			return fmt.Errorf("input %v is not controlled by key %v", inputIndex, keyIndex)
+		}
+		inputIndices = append(inputIndices, inputIndex)
+	}
+	sort.Ints(inputIndices)
+	fmt.Fprintln(infoOut(), "Please verify the transaction details:")
 	for _, sco := range txn.SiacoinOutputs {
-		fmt.Println("   ", sco.UnlockHash, "receiving", currencyUnits(sco.Value))
+		fmt.Fprintln(infoOut(), "   ", sco.UnlockHash, "receiving", currencyUnits(sco.Value))
 	}
+	printSiafundClaims(infoOut(), *txn)
 	for _, fee := range txn.MinerFees {
-		fmt.Println("    A miner fee of", currencyUnits(fee))
+		fmt.Fprintln(infoOut(), "    A miner fee of", currencyUnits(fee))
 	}
-	fmt.Print("Press ENTER to sign this transaction, or Ctrl-C to cancel.")
+	printParentOutputs(infoOut(), *txn)
+	fmt.Fprint(infoOut(), "Press ENTER to sign this transaction, or Ctrl-C to cancel.")
 	bufio.NewReader(os.Stdin).ReadLine()
+	for _, inputIndex := range inputIndices {
+		sig := newSignature(crypto.Hash(txn.SiacoinInputs[inputIndex].ParentID))
+		wallet.AppendTransactionSignature(txn, sig, seed.SecretKey(keyHints[inputIndex]))
+	}
+	return nil
+}
 
-	old := len(txn.TransactionSignatures)
-	err := c.ProtoWallet(seed).SignTransaction(txn, nil)
-	if err != nil {
+// signFlowHot signs txn using the seed. If only is non-empty, only the inputs
+// at those indices are signed; otherwise, all wallet-controlled inputs are
+// signed.
+func signFlowHot(c *walrus.Client, txn *types.Transaction, only []int) error {
+	seed := getSeed()
+	var pending []types.TransactionSignature
+	if len(only) == 0 {
+		owned := ownedAddresses(c)
+		for _, in := range txn.SiacoinInputs {
+			if owned[in.UnlockConditions.UnlockHash()] {
+				pending = append(pending, newSignature(crypto.Hash(in.ParentID)))
+			}
+		}
+	} else {
+		for _, i := range only {
+			pending = append(pending, newSignature(crypto.Hash(txn.SiacoinInputs[i].ParentID)))
+		}
+	}
+	if err := checkKeyTypes(*txn, pending); err != nil {
 		return err
-	} else if old == len(txn.TransactionSignatures) {
-		fmt.Println("Nothing to sign: transaction does not spend any outputs recognized by this wallet")
+	}
+	fmt.Fprintln(infoOut(), "Please verify the transaction details:")
+	for _, sco := range txn.SiacoinOutputs {
+		fmt.Fprintln(infoOut(), "   ", sco.UnlockHash, "receiving", currencyUnits(sco.Value))
+	}
+	printSiafundClaims(infoOut(), *txn)
+	for _, fee := range txn.MinerFees {
+		fmt.Fprintln(infoOut(), "    A miner fee of", currencyUnits(fee))
+	}
+	printParentOutputs(infoOut(), *txn)
+	checkFeeRate(c, *txn)
+	fmt.Fprint(infoOut(), "Press ENTER to sign this transaction, or Ctrl-C to cancel.")
+	bufio.NewReader(os.Stdin).ReadLine()
+
+	old := len(txn.TransactionSignatures)
+	var toSign []crypto.Hash
+	for _, sig := range pending {
+		txn.TransactionSignatures = append(txn.TransactionSignatures, sig)
+		toSign = append(toSign, sig.ParentID)
+	}
+	if len(toSign) > 0 {
+		err := c.ProtoWallet(seed).SignTransaction(txn, toSign)
+		if err != nil {
+			return err
+		}
+	}
+	if len(only) == 0 {
+		sigs, keys := nonStandardSigs(c, *txn)
+		for i, sig := range sigs {
+			wallet.AppendTransactionSignature(txn, sig, seed.SecretKey(keys[i]))
+		}
+	}
+	if old == len(txn.TransactionSignatures) {
+		fmt.Fprintln(infoOut(), "Nothing to sign: transaction does not spend any outputs recognized by this wallet")
 		return nil
 	}
 	return nil